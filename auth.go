@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// authLevel is the kind of credentials a route asks for.
+type authLevel string
+
+const (
+	authNone   authLevel = "none"   // Anyone may call the route.
+	authAPIKey authLevel = "apikey" // An X-API-Key header matching API_KEY.
+	authJWT    authLevel = "jwt"    // An "Authorization: Bearer" JWT signed with JWT_SECRET.
+	authAdmin  authLevel = "admin"  // An X-Admin-Token header matching ADMIN_TOKEN.
+)
+
+// defaultAuthPolicy says what each route requires unless AUTH_POLICY says
+// otherwise. Keys are a route pattern, optionally preceded by a method, and a
+// pattern ending in "/*" covers everything below it as well as itself; see
+// authLevelFor for which key wins. Routes nothing matches are public.
+//
+// Everything about the items, reads included, needs the API key. Health
+// checks come from load balancers and orchestrators that don't have one, so
+// /healthz is listed as public outright, which no wildcard like "/*" overrides.
+var defaultAuthPolicy = map[string]authLevel{
+	"/admin/*":    authAdmin,
+	"/items/*":    authAPIKey,
+	"/items.html": authAPIKey,
+	"/healthz":    authNone,
+}
+
+// authPolicyFromEnv returns defaultAuthPolicy with the entries from
+// AUTH_POLICY added on top. AUTH_POLICY is a comma-separated list of
+// key=level pairs, like "GET /schema=apikey,/slow=admin".
+func authPolicyFromEnv() (map[string]authLevel, error) {
+	policy := make(map[string]authLevel, len(defaultAuthPolicy))
+	for key, level := range defaultAuthPolicy {
+		policy[key] = level
+	}
+	v := os.Getenv("AUTH_POLICY")
+	if v == "" {
+		return policy, nil
+	}
+	for _, entry := range strings.Split(v, ",") {
+		key, level, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("AUTH_POLICY entry %q is not key=level", entry)
+		}
+		switch l := authLevel(level); l {
+		case authNone, authAPIKey, authJWT, authAdmin:
+			policy[key] = l
+		default:
+			return nil, fmt.Errorf("AUTH_POLICY entry %q: unknown level %q", entry, level)
+		}
+	}
+	return policy, nil
+}
+
+// authLevelFor looks up what policy requires of the route method pattern. The
+// most specific key wins: the exact pattern before a wildcard, a longer
+// wildcard before a shorter one, and at each of those, a key naming the
+// method before one that doesn't.
+func authLevelFor(policy map[string]authLevel, method, pattern string) authLevel {
+	keys := []string{method + " " + pattern, pattern}
+	// Walk up the path one segment at a time, ending with "/*" for everything.
+	for prefix := strings.TrimSuffix(pattern, "/"); ; {
+		keys = append(keys, method+" "+prefix+"/*", prefix+"/*")
+		if prefix == "" {
+			break
+		}
+		prefix = prefix[:strings.LastIndex(prefix, "/")]
+	}
+	for _, key := range keys {
+		if level, found := policy[key]; found {
+			return level
+		}
+	}
+	return authNone
+}
+
+// requireAuth wraps next so it's only called with the credentials level asks
+// for, answering 401 Unauthorized otherwise. A valid admin token is accepted
+// wherever API keys or JWTs are. A level whose secret isn't configured (say,
+// API_KEY is unset) isn't enforced, so a server started without any secrets
+// stays as open as it always was. Admin routes are the exception once any
+// secret is set: they're closed then, ADMIN_TOKEN or not.
+func (s *server) requireAuth(level authLevel, next http.Handler) http.Handler {
+	if level == authNone {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.authConfigured(level) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		principal, err := s.authenticate(level, r)
+		if err != nil {
+			writeJSONError(w, http.StatusUnauthorized, fmt.Sprintf("Unauthorized: %v", err))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)))
+	})
+}
+
+// authConfigured reports whether the secret that level checks against is set.
+// For admin routes it's enough that any secret is: a server protecting its
+// items with API_KEY alone mustn't leave pausing it or dumping it open to all,
+// so without ADMIN_TOKEN those routes refuse everyone.
+func (s *server) authConfigured(level authLevel) bool {
+	switch level {
+	case authAPIKey:
+		return s.apiKey != ""
+	case authJWT:
+		return len(s.jwtSecret) > 0
+	case authAdmin:
+		return s.adminToken != "" || s.apiKey != "" || len(s.jwtSecret) > 0
+	}
+	return false
+}
+
+// authenticate checks r's credentials against level, returning who they
+// belong to: the JWT's subject, or "apikey" or "admin" for the shared secrets.
+func (s *server) authenticate(level authLevel, r *http.Request) (string, error) {
+	if s.adminToken != "" && secureEqual(r.Header.Get("X-Admin-Token"), s.adminToken) {
+		return "admin", nil
+	}
+	switch level {
+	case authAPIKey:
+		key := r.Header.Get("X-API-Key")
+		if key == "" {
+			return "", errors.New("missing X-API-Key header")
+		}
+		if !secureEqual(key, s.apiKey) {
+			return "", errors.New("invalid API key")
+		}
+		return "apikey", nil
+	case authJWT:
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			return "", errors.New("missing bearer token")
+		}
+		return verifyJWT(token, s.jwtSecret, s.now())
+	}
+	return "", errors.New("admin token required")
+}
+
+// secureEqual compares a credential in constant time, so how long a wrong
+// guess takes to reject says nothing about how close it was.
+func secureEqual(got, want string) bool {
+	return subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+// verifyJWT checks that token is a JWT signed with HS256 using secret and not
+// expired at now, and returns its subject.
+func verifyJWT(token string, secret []byte, now time.Time) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil || header.Alg != "HS256" {
+		return "", errors.New("token must be signed with HS256")
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sig, mac.Sum(nil)) {
+		return "", errors.New("invalid token signature")
+	}
+
+	var claims struct {
+		Sub string `json:"sub"`
+		Exp int64  `json:"exp"`
+	}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return "", errors.New("malformed token claims")
+	}
+	if claims.Exp != 0 && now.Unix() >= claims.Exp {
+		return "", errors.New("token expired")
+	}
+	if claims.Sub == "" {
+		return "", errors.New("token has no subject")
+	}
+	return claims.Sub, nil
+}
+
+// decodeJWTPart decodes one base64url-encoded JSON part of a JWT into v.
+func decodeJWTPart(part string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// principalKey is the context key requireAuth files the caller's identity under.
+type principalKey struct{}
+
+// principalFrom returns who made the request, as worked out by requireAuth,
+// or "" if the route didn't ask for credentials.
+func principalFrom(ctx context.Context) string {
+	principal, _ := ctx.Value(principalKey{}).(string)
+	return principal
+}
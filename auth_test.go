@@ -0,0 +1,303 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRoutesEnforceAuthPolicy checks that each route asks for the credentials
+// the default policy gives it: an API key for the items, the admin token for
+// /admin, and nothing for the rest.
+func TestRoutesEnforceAuthPolicy(t *testing.T) {
+	server := newServer()
+	server.apiKey = "items-key"
+	server.adminToken = "admin-token"
+	server.putItem(Item{ID: 1, Name: "Alice", Age: 30})
+
+	tests := []struct {
+		method, path, body string
+		level              authLevel
+	}{
+		{"GET", "/items", "", authAPIKey},
+		{"GET", "/items/1", "", authAPIKey},
+		{"GET", "/items/1/name", "", authAPIKey},
+		{"GET", "/items.html", "", authAPIKey},
+		{"GET", "/schema", "", authNone},
+		{"GET", "/healthz", "", authNone},
+		{"POST", "/items", `{"name":"Bob","age":40}`, authAPIKey},
+		{"PUT", "/items/1", `{"name":"Alice","age":31}`, authAPIKey},
+		{"PATCH", "/items", `{"ids":[1],"patch":{"age":32}}`, authAPIKey},
+		{"POST", "/items/reorder", `[1]`, authAPIKey},
+		{"DELETE", "/items/1", "", authAPIKey},
+		{"GET", "/admin/draining", "", authAdmin},
+		{"GET", "/admin/replica-info", "", authAdmin},
+	}
+	// send makes the request with the given header set, if any.
+	send := func(method, path, body, header, value string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	for _, tt := range tests {
+		route := tt.method + " " + tt.path
+		// Without credentials, only public routes let the request through.
+		if code := send(tt.method, tt.path, tt.body, "", ""); (code == http.StatusUnauthorized) != (tt.level != authNone) {
+			t.Errorf("%s without credentials: got %v, want 401 only if it requires %s", route, code, tt.level)
+		}
+		switch tt.level {
+		case authAPIKey:
+			if code := send(tt.method, tt.path, tt.body, "X-API-Key", "wrong"); code != http.StatusUnauthorized {
+				t.Errorf("%s with a wrong API key: got %v want %v", route, code, http.StatusUnauthorized)
+			}
+			if code := send(tt.method, tt.path, tt.body, "X-API-Key", "items-key"); code == http.StatusUnauthorized {
+				t.Errorf("%s with the API key: got %v", route, code)
+			}
+		case authAdmin:
+			if code := send(tt.method, tt.path, tt.body, "X-API-Key", "items-key"); code != http.StatusUnauthorized {
+				t.Errorf("%s with only an API key: got %v want %v", route, code, http.StatusUnauthorized)
+			}
+			if code := send(tt.method, tt.path, tt.body, "X-Admin-Token", "admin-token"); code == http.StatusUnauthorized {
+				t.Errorf("%s with the admin token: got %v", route, code)
+			}
+		}
+	}
+}
+
+// TestAPIKeyAuth checks the /items routes with the right API key, a wrong
+// one, none at all, and with no API_KEY configured, plus that /healthz never
+// asks for one.
+func TestAPIKeyAuth(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured string // The server's API_KEY.
+		sent       string // The client's X-API-Key.
+		path       string
+		want       int
+	}{
+		{"valid key", "secret", "secret", "/items", http.StatusOK},
+		{"invalid key", "secret", "guess", "/items", http.StatusUnauthorized},
+		{"missing key", "secret", "", "/items", http.StatusUnauthorized},
+		{"no auth configured", "", "", "/items", http.StatusOK},
+		{"no auth configured, key sent anyway", "", "whatever", "/items", http.StatusOK},
+		{"health check without a key", "secret", "", "/healthz", http.StatusOK},
+	}
+	for _, tt := range tests {
+		server := newServer()
+		server.apiKey = tt.configured
+		req := httptest.NewRequest("GET", tt.path, nil)
+		if tt.sent != "" {
+			req.Header.Set("X-API-Key", tt.sent)
+		}
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+		if rr.Code != tt.want {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", tt.name, rr.Code, tt.want)
+		}
+	}
+}
+
+// TestRequireAuthDisabled checks that a level whose secret isn't configured
+// isn't enforced.
+func TestRequireAuthDisabled(t *testing.T) {
+	server := newServer()
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("POST", "/items", strings.NewReader(`{"name":"Bob","age":40}`)))
+	if status := rr.Code; status != http.StatusCreated {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+	}
+}
+
+// TestAdminRoutesClosedWithoutAdminToken checks that setting API_KEY alone
+// shuts the admin routes, even to a client with the API key, rather than
+// leaving them open because ADMIN_TOKEN isn't set.
+func TestAdminRoutesClosedWithoutAdminToken(t *testing.T) {
+	server := newServer()
+	server.apiKey = "secret"
+
+	for _, route := range []struct{ method, path string }{
+		{"POST", "/admin/pause"},
+		{"GET", "/admin/draining"},
+		{"GET", "/admin/buildinfo"},
+	} {
+		req := httptest.NewRequest(route.method, route.path, nil)
+		req.Header.Set("X-API-Key", "secret")
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s: handler returned wrong status code: got %v want %v", route.method, route.path, rr.Code, http.StatusUnauthorized)
+		}
+	}
+	if server.paused.Load() {
+		t.Errorf("server was paused without an admin token")
+	}
+}
+
+// TestAuthLevelFor checks which policy key wins for a route.
+func TestAuthLevelFor(t *testing.T) {
+	policy := map[string]authLevel{
+		"/admin/*":               authAdmin,
+		"GET /admin/draining":    authNone,
+		"/items/*":               authAPIKey,
+		"GET /items/*":           authNone,
+		"/items/{id}/avatar/*":   authJWT,
+		"DELETE /items/{id}/*":   authAdmin,
+		"POST /items/import/csv": authAdmin,
+	}
+	tests := []struct {
+		method, pattern string
+		want            authLevel
+	}{
+		{"GET", "/admin/draining", authNone},
+		{"POST", "/admin/pause", authAdmin},
+		{"GET", "/items", authNone},
+		{"POST", "/items", authAPIKey},
+		{"GET", "/items/{id}", authNone},
+		{"PUT", "/items/{id}", authAPIKey},
+		{"DELETE", "/items/{id}", authAdmin},
+		{"GET", "/items/{id}/avatar", authJWT},
+		{"PUT", "/items/{id}/avatar", authJWT},
+		{"POST", "/items/import/csv", authAdmin},
+		{"GET", "/healthz", authNone},
+	}
+	for _, tt := range tests {
+		if got := authLevelFor(policy, tt.method, tt.pattern); got != tt.want {
+			t.Errorf("%s %s: got %q want %q", tt.method, tt.pattern, got, tt.want)
+		}
+	}
+}
+
+// TestAuthPolicyFromEnv checks AUTH_POLICY entries are added on top of the
+// default policy, and that unknown levels are refused.
+func TestAuthPolicyFromEnv(t *testing.T) {
+	t.Setenv("AUTH_POLICY", "GET /schema=jwt, /slow=admin")
+	policy, err := authPolicyFromEnv()
+	if err != nil {
+		t.Fatalf("could not read policy: %v", err)
+	}
+	if policy["GET /schema"] != authJWT || policy["/slow"] != authAdmin || policy["/admin/*"] != authAdmin {
+		t.Errorf("got policy %v", policy)
+	}
+
+	t.Setenv("AUTH_POLICY", "/items/*=everyone")
+	if _, err := authPolicyFromEnv(); err == nil {
+		t.Errorf("expected an error for an unknown level")
+	}
+}
+
+// TestRequireAuthJWT checks JWT-protected routes accept a validly signed,
+// unexpired token and pass its subject on to the handler.
+func TestRequireAuthJWT(t *testing.T) {
+	server := newServer()
+	server.jwtSecret = []byte("jwt-secret")
+	server.now = func() time.Time { return time.Unix(1_000_000, 0) }
+
+	var principal string
+	handler := server.requireAuth(authJWT, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal = principalFrom(r.Context())
+	}))
+
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"valid", signJWT(`{"alg":"HS256"}`, `{"sub":"alice","exp":2000000}`, "jwt-secret"), http.StatusOK},
+		{"no expiry", signJWT(`{"alg":"HS256"}`, `{"sub":"alice"}`, "jwt-secret"), http.StatusOK},
+		{"expired", signJWT(`{"alg":"HS256"}`, `{"sub":"alice","exp":999999}`, "jwt-secret"), http.StatusUnauthorized},
+		{"wrong secret", signJWT(`{"alg":"HS256"}`, `{"sub":"alice"}`, "other-secret"), http.StatusUnauthorized},
+		{"unsigned", signJWT(`{"alg":"none"}`, `{"sub":"alice"}`, "jwt-secret"), http.StatusUnauthorized},
+		{"no subject", signJWT(`{"alg":"HS256"}`, `{}`, "jwt-secret"), http.StatusUnauthorized},
+		{"garbage", "not-a-token", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		principal = ""
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+tt.token)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != tt.want {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", tt.name, rr.Code, tt.want)
+		}
+		if tt.want == http.StatusOK && principal != "alice" {
+			t.Errorf("%s: handler saw principal %q want %q", tt.name, principal, "alice")
+		}
+	}
+}
+
+// signJWT builds a JWT from the given header and claims, signed with HS256.
+func signJWT(header, claims, secret string) string {
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString([]byte(header)) + "." + enc.EncodeToString([]byte(claims))
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + enc.EncodeToString(mac.Sum(nil))
+}
+
+// TestListItemsModifiedBy creates and updates items as different JWT
+// subjects, and checks each item records who last changed it and that
+// modified_by lists just theirs.
+func TestListItemsModifiedBy(t *testing.T) {
+	t.Setenv("AUTH_POLICY", "/items/*=jwt")
+	server := newServer()
+	server.jwtSecret = []byte("jwt-secret")
+
+	// send makes a request as the given subject.
+	send := func(method, path, body, sub string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+signJWT(`{"alg":"HS256"}`, `{"sub":"`+sub+`"}`, "jwt-secret"))
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+		return rr
+	}
+	send("POST", "/items", `{"id":1,"name":"Alice","age":30}`, "alice")
+	send("POST", "/items", `{"id":2,"name":"Bob","age":40}`, "bob")
+	send("POST", "/items", `{"id":3,"name":"Carol","age":50}`, "alice")
+	// Bob takes over item 3 by changing it.
+	if rr := send("PUT", "/items/3", `{"name":"Carol","age":51}`, "bob"); rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if got := storedItem(server, 3).ModifiedBy; got != "bob" {
+		t.Errorf("item 3 modified by %q want %q", got, "bob")
+	}
+
+	tests := []struct {
+		url     string
+		wantIDs []int
+	}{
+		{"/items?modified_by=alice", []int{1}},
+		{"/items?modified_by=bob", []int{2, 3}},
+		{"/items?modified_by=mallory", nil},
+		{"/items", []int{1, 2, 3}},
+	}
+	for _, tt := range tests {
+		rr := send("GET", tt.url, "", "carol")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: handler returned wrong status code: got %v want %v", tt.url, rr.Code, http.StatusOK)
+		}
+		var list itemList
+		if err := json.NewDecoder(rr.Body).Decode(&list); err != nil {
+			t.Fatalf("%s: could not decode response body: %v", tt.url, err)
+		}
+		var gotIDs []int
+		for _, item := range list.Items {
+			gotIDs = append(gotIDs, item.ID)
+		}
+		if !slices.Equal(gotIDs, tt.wantIDs) {
+			t.Errorf("%s: got IDs %v want %v", tt.url, gotIDs, tt.wantIDs)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// maxAvatarSize is the largest avatar image we'll store, in bytes.
+const maxAvatarSize = 256 << 10
+
+// handlePutAvatar handles requests to attach an image to an item, sent as the
+// "avatar" file of a multipart/form-data upload (e.g., PUT /items/101/avatar).
+func (s *server) handlePutAvatar() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			s.reqLogf(r, "ERROR converting ID to int: %v", err)
+			writeJSONError(w, http.StatusBadRequest, "Invalid item ID")
+			return
+		}
+		if _, found := s.store.Get(id); !found {
+			writeJSONError(w, http.StatusNotFound, "Item not found")
+			return
+		}
+
+		// Read the upload part by part rather than parsing the whole form, so
+		// nothing larger than the limit is ever held in memory or spilled to disk.
+		mr, err := r.MultipartReader()
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Bad request: expected a multipart/form-data upload")
+			return
+		}
+		var data []byte
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, "Bad request: malformed multipart body")
+				return
+			}
+			if part.FormName() != "avatar" {
+				continue
+			}
+			// Read one byte past the limit so we can tell when it was exceeded.
+			data, err = io.ReadAll(io.LimitReader(part, maxAvatarSize+1))
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, "Bad request: malformed multipart body")
+				return
+			}
+			break
+		}
+		if data == nil {
+			writeJSONError(w, http.StatusBadRequest, `Bad request: missing "avatar" file`)
+			return
+		}
+		if len(data) > maxAvatarSize {
+			s.reqLogf(r, "Rejected oversized avatar for item %d", id)
+			writeJSONError(w, http.StatusRequestEntityTooLarge, "Avatar too large")
+			return
+		}
+
+		// The item may have been deleted while the upload was coming in, so
+		// check again before attaching the avatar to it.
+		s.mu.Lock()
+		_, found := s.store.Get(id)
+		if found {
+			s.avatars[id] = data
+		}
+		s.mu.Unlock()
+		if !found {
+			writeJSONError(w, http.StatusNotFound, "Item not found")
+			return
+		}
+		s.reqLogf(r, "Stored %d byte avatar for item %d", len(data), id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleGetAvatar handles requests for an item's stored image (e.g., GET
+// /items/101/avatar). The Content-Type is sniffed from the image bytes.
+func (s *server) handleGetAvatar() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			s.reqLogf(r, "ERROR converting ID to int: %v", err)
+			writeJSONError(w, http.StatusBadRequest, "Invalid item ID")
+			return
+		}
+		s.mu.RLock()
+		data, found := s.avatars[id]
+		s.mu.RUnlock()
+		if !found {
+			writeJSONError(w, http.StatusNotFound, "Avatar not found")
+			return
+		}
+
+		w.Header().Set("Content-Type", http.DetectContentType(data))
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Write(data)
+	}
+}
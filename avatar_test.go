@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// pngHeader is enough of a PNG file for http.DetectContentType to recognise it.
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+// newAvatarRequest builds a multipart PUT request uploading data as the avatar
+// of the item with the given ID.
+func newAvatarRequest(t *testing.T, id string, data []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("avatar", "avatar.png")
+	if err != nil {
+		t.Fatalf("could not create form file: %v", err)
+	}
+	fw.Write(data)
+	mw.Close()
+
+	req := httptest.NewRequest("PUT", "/items/"+id+"/avatar", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+// TestAvatarUploadAndGet checks that an uploaded avatar is served back with
+// the sniffed image content type.
+func TestAvatarUploadAndGet(t *testing.T) {
+	server := newServer()
+	server.putItem(Item{ID: 1, Name: "Alice", Age: 30})
+
+	// Before any upload there's nothing to serve.
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("GET", "/items/1/avatar", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("GET before upload: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	rr = httptest.NewRecorder()
+	server.router.ServeHTTP(rr, newAvatarRequest(t, "1", pngHeader))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("upload: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+
+	rr = httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("GET", "/items/1/avatar", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GET after upload: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "image/png" {
+		t.Errorf("got Content-Type %q want image/png", ct)
+	}
+	if !bytes.Equal(rr.Body.Bytes(), pngHeader) {
+		t.Errorf("served avatar differs from the upload")
+	}
+}
+
+// TestAvatarUploadRejections checks the error paths: uploading to a missing
+// item, and uploading an image that's over the size limit.
+func TestAvatarUploadRejections(t *testing.T) {
+	server := newServer()
+	server.putItem(Item{ID: 1, Name: "Alice", Age: 30})
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, newAvatarRequest(t, "2", pngHeader))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("upload to missing item: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	rr = httptest.NewRecorder()
+	server.router.ServeHTTP(rr, newAvatarRequest(t, "1", make([]byte, maxAvatarSize+1)))
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized upload: got %v want %v", rr.Code, http.StatusRequestEntityTooLarge)
+	}
+	if _, found := server.avatars[1]; found {
+		t.Error("oversized avatar was stored")
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// buildInfo is the body returned by GET /admin/buildinfo.
+type buildInfo struct {
+	Race      bool              `json:"race"`
+	GoVersion string            `json:"go_version"`
+	Path      string            `json:"path"`    // The main package's import path.
+	Version   string            `json:"version"` // The main module's version, "(devel)" for a local build.
+	Settings  map[string]string `json:"settings"`
+}
+
+// handleBuildInfo handles requests for how the running binary was built (e.g.,
+// GET /admin/buildinfo): whether the race detector is in, which Go compiled
+// it, and the build settings the toolchain recorded, such as the VCS revision
+// and GOOS/GOARCH. It confirms what's actually deployed.
+func (s *server) handleBuildInfo() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info := buildInfo{
+			Race:      raceEnabled,
+			GoVersion: runtime.Version(),
+			Settings:  map[string]string{},
+		}
+		// Binaries built without module support have no build info; the rest
+		// of the report still stands without it.
+		if bi, ok := debug.ReadBuildInfo(); ok {
+			info.Path = bi.Path
+			info.Version = bi.Main.Version
+			for _, setting := range bi.Settings {
+				info.Settings[setting.Key] = setting.Value
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(info)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+)
+
+// TestHandleBuildInfo checks that the build info reports the race detector
+// status, the Go version and the module build settings.
+func TestHandleBuildInfo(t *testing.T) {
+	server := newServer()
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/buildinfo", nil))
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var info map[string]any
+	if err := json.NewDecoder(rr.Body).Decode(&info); err != nil {
+		t.Fatalf("could not decode response body: %v", err)
+	}
+	for _, field := range []string{"race", "go_version", "path", "version", "settings"} {
+		if _, ok := info[field]; !ok {
+			t.Errorf("build info has no %q field: %v", field, info)
+		}
+	}
+	if info["race"] != raceEnabled {
+		t.Errorf("got race %v want %v", info["race"], raceEnabled)
+	}
+	if info["go_version"] != runtime.Version() {
+		t.Errorf("got go_version %v want %v", info["go_version"], runtime.Version())
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"os"
+	"strconv"
+)
+
+// itemRead is the shared result of one coalesced item lookup.
+type itemRead struct {
+	body  []byte
+	found bool
+}
+
+// coalesceReadsFromEnv reports whether identical concurrent reads should share
+// one lookup. It's on unless COALESCE_READS is set to something false, like "0"
+// or "false".
+func coalesceReadsFromEnv() bool {
+	on, err := strconv.ParseBool(os.Getenv("COALESCE_READS"))
+	return err != nil || on
+}
+
+// readItemJSON returns the JSON encoding of the item with the given ID, and
+// whether there is such an item. While coalescing is on, concurrent calls for
+// the same ID wait for a single lookup and all get its result, so a burst of
+// identical GETs costs the store one query rather than one each.
+//
+// A caller that joins a lookup already under way can get an answer that's a
+// moment older than a write which finished just before it arrived. That's the
+// same answer it would have got by arriving a moment earlier, so GETs don't
+// need anything stronger.
+func (s *server) readItemJSON(id int) ([]byte, bool) {
+	if !s.coalesceReads {
+		return s.lookupItemJSON(id)
+	}
+	v, _, shared := s.reads.Do(strconv.Itoa(id), func() (any, error) {
+		body, found := s.lookupItemJSON(id)
+		return itemRead{body: body, found: found}, nil
+	})
+	if shared {
+		s.debugf("Lookup of item %d shared between concurrent requests", id)
+	}
+	read := v.(itemRead)
+	return read.body, read.found
+}
+
+// cachedItemJSON is the default lookupItemJSON: it reads the item from the
+// datastore and returns its (possibly cached) JSON encoding.
+func (s *server) cachedItemJSON(id int) ([]byte, bool) {
+	// The cache is filled while we still hold the read lock, so no writer can
+	// change the item between encoding it and caching the result.
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	item, found := s.store.Get(id)
+	if !found {
+		return nil, false
+	}
+	return s.itemJSONBytes(item), true
+}
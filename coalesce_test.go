@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestConcurrentGetsShareOneLookup fires a burst of identical GETs while the
+// store is slow to answer, and checks that it was only asked once.
+func TestConcurrentGetsShareOneLookup(t *testing.T) {
+	s := newServer()
+	s.coalesceReads = true
+	s.putItem(Item{ID: 7, Name: "Herd", Age: 1})
+
+	// Every lookup blocks until release is closed, so the requests pile up
+	// behind the first one.
+	var lookups atomic.Int32
+	release := make(chan struct{})
+	s.lookupItemJSON = func(id int) ([]byte, bool) {
+		lookups.Add(1)
+		<-release
+		return s.cachedItemJSON(id)
+	}
+
+	const clients = 20
+	var wg sync.WaitGroup
+	codes := make([]int, clients)
+	for i := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest("GET", "/items/7", nil)
+			rr := httptest.NewRecorder()
+			s.router.ServeHTTP(rr, req)
+			codes[i] = rr.Code
+		}()
+	}
+	// Give every request time to reach the lookup before letting it finish.
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := lookups.Load(); n != 1 {
+		t.Errorf("store was queried %d times, want 1", n)
+	}
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("request %d returned wrong status code: got %v want %v", i, code, http.StatusOK)
+		}
+	}
+}
+
+// TestGetsWithoutCoalescing checks that each GET does its own lookup once
+// coalescing has been turned off.
+func TestGetsWithoutCoalescing(t *testing.T) {
+	s := newServer()
+	s.coalesceReads = false
+	s.putItem(Item{ID: 7, Name: "Herd", Age: 1})
+
+	var lookups atomic.Int32
+	s.lookupItemJSON = func(id int) ([]byte, bool) {
+		lookups.Add(1)
+		return s.cachedItemJSON(id)
+	}
+	for range 3 {
+		req, _ := http.NewRequest("GET", "/items/7", nil)
+		s.router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if n := lookups.Load(); n != 3 {
+		t.Errorf("store was queried %d times, want 3", n)
+	}
+}
+
+// TestCoalesceReadsFromEnv checks that coalescing is on unless COALESCE_READS
+// turns it off.
+func TestCoalesceReadsFromEnv(t *testing.T) {
+	for value, want := range map[string]bool{"": true, "true": true, "1": true, "false": false, "0": false} {
+		t.Setenv("COALESCE_READS", value)
+		if got := coalesceReadsFromEnv(); got != want {
+			t.Errorf("COALESCE_READS=%q: got %v want %v", value, got, want)
+		}
+	}
+}
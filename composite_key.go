@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// compositeKey is the business key of an item in composite-key mode: no two
+// items may share both a name and an age.
+type compositeKey struct {
+	name string
+	age  int
+}
+
+// keyOf returns the composite key of item.
+func keyOf(item Item) compositeKey {
+	return compositeKey{name: item.Name, age: item.Age}
+}
+
+// keyTakenError is returned by putItem when an item would take a composite
+// key that another item already has.
+type keyTakenError struct {
+	key   compositeKey
+	owner int // The ID of the item that has the key.
+}
+
+func (e *keyTakenError) Error() string {
+	return fmt.Sprintf("name %q and age %d already in use by item %d", e.key.name, e.key.age, e.owner)
+}
+
+// enableCompositeKeys switches on composite-key mode, indexing the items that
+// are already stored. It fails if any of them share a key, since uniqueness
+// couldn't be enforced from then on.
+func (s *server) enableCompositeKeys() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	byKey := make(map[compositeKey]int)
+	for _, item := range s.store.List() {
+		key := keyOf(item)
+		if owner, taken := byKey[key]; taken {
+			return fmt.Errorf("items %d and %d share name %q and age %d", owner, item.ID, key.name, key.age)
+		}
+		byKey[key] = item.ID
+	}
+	s.byKey = byKey
+	return nil
+}
+
+// checkKey makes sure storing item wouldn't give it a composite key another
+// item already has. It always passes outside composite-key mode. s.mu must be
+// held, for reading at least.
+func (s *server) checkKey(item Item) error {
+	if s.byKey == nil {
+		return nil
+	}
+	if owner, taken := s.byKey[keyOf(item)]; taken && owner != item.ID {
+		return &keyTakenError{key: keyOf(item), owner: owner}
+	}
+	return nil
+}
+
+// indexKey records item under its composite key, in composite-key mode.
+// s.mu must be held for writing.
+func (s *server) indexKey(item Item) {
+	if s.byKey != nil {
+		s.byKey[keyOf(item)] = item.ID
+	}
+}
+
+// unindexKey forgets the composite key of item, which is being replaced or
+// removed. s.mu must be held for writing.
+func (s *server) unindexKey(item Item) {
+	if s.byKey != nil {
+		delete(s.byKey, keyOf(item))
+	}
+}
+
+// handleGetItemByKey handles requests to look an item up by its composite key
+// (e.g., GET /items/by-key?name=Alice&age=30), in composite-key mode.
+func (s *server) handleGetItemByKey() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("name")
+		age, err := strconv.Atoi(r.URL.Query().Get("age"))
+		if name == "" || err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Bad request: name and a numeric age are required")
+			return
+		}
+
+		s.mu.RLock()
+		if s.byKey == nil {
+			s.mu.RUnlock()
+			writeJSONError(w, http.StatusNotFound, "Not found: composite keys are not enabled")
+			return
+		}
+		id, found := s.byKey[compositeKey{name: name, age: age}]
+		var item Item
+		if found {
+			item, found = s.store.Get(id)
+		}
+		s.mu.RUnlock()
+		if !found {
+			writeJSONError(w, http.StatusNotFound, "Item not found")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(item)
+	}
+}
+
+// isKeyTaken reports whether err is a keyTakenError.
+func isKeyTaken(err error) bool {
+	var kt *keyTakenError
+	return errors.As(err, &kt)
+}
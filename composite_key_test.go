@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newCompositeKeyServer returns a server in composite-key mode.
+func newCompositeKeyServer(t *testing.T) *server {
+	t.Helper()
+	server := newServer()
+	if err := server.enableCompositeKeys(); err != nil {
+		t.Fatalf("could not enable composite keys: %v", err)
+	}
+	return server
+}
+
+// sendTo serves one request on server and returns the recorder.
+func sendTo(server *server, method, path, body string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return rr
+}
+
+// TestGetItemByKey checks looking items up by name and age, including after
+// an update moves an item to a new key.
+func TestGetItemByKey(t *testing.T) {
+	server := newCompositeKeyServer(t)
+	sendTo(server, "POST", "/items", `{"id":1,"name":"Alice","age":30}`)
+	sendTo(server, "POST", "/items", `{"id":2,"name":"Alice","age":31}`)
+
+	rr := sendTo(server, "GET", "/items/by-key?name=Alice&age=31", "")
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	var item Item
+	if err := json.NewDecoder(rr.Body).Decode(&item); err != nil {
+		t.Fatalf("could not decode response body: %v", err)
+	}
+	if item.ID != 2 {
+		t.Errorf("found the wrong item: got %+v", item)
+	}
+
+	// Moving item 1 to a new key frees its old one.
+	sendTo(server, "PUT", "/items/1", `{"id":1,"name":"Alice","age":40}`)
+	if rr := sendTo(server, "GET", "/items/by-key?name=Alice&age=30", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("old key after update: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+	if rr := sendTo(server, "GET", "/items/by-key?name=Alice&age=40", ""); rr.Code != http.StatusOK {
+		t.Errorf("new key after update: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	// Deleting an item frees its key too.
+	sendTo(server, "DELETE", "/items/2", "")
+	if rr := sendTo(server, "GET", "/items/by-key?name=Alice&age=31", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("key after delete: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	if rr := sendTo(server, "GET", "/items/by-key?name=Alice", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("missing age: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
+
+// TestCompositeKeyUniqueness checks that no write can give two items the same
+// name and age.
+func TestCompositeKeyUniqueness(t *testing.T) {
+	server := newCompositeKeyServer(t)
+	sendTo(server, "POST", "/items", `{"id":1,"name":"Alice","age":30}`)
+	sendTo(server, "POST", "/items", `{"id":2,"name":"Bob","age":30}`)
+
+	if rr := sendTo(server, "POST", "/items", `{"id":3,"name":"Alice","age":30}`); rr.Code != http.StatusConflict {
+		t.Errorf("create with a taken key: got %v want %v", rr.Code, http.StatusConflict)
+	}
+	if rr := sendTo(server, "PUT", "/items/2", `{"id":2,"name":"Alice","age":30}`); rr.Code != http.StatusConflict {
+		t.Errorf("update to a taken key: got %v want %v", rr.Code, http.StatusConflict)
+	}
+	// An item keeping its own key is fine.
+	if rr := sendTo(server, "PUT", "/items/1", `{"id":1,"name":"Alice","age":30}`); rr.Code != http.StatusOK {
+		t.Errorf("update keeping its own key: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	rr := sendTo(server, "PATCH", "/items", `{"ids":[2],"patch":{"name":"Alice"}}`)
+	var results []bulkPatchResult
+	if err := json.NewDecoder(rr.Body).Decode(&results); err != nil {
+		t.Fatalf("could not decode response body: %v", err)
+	}
+	if len(results) != 1 || results[0].Status != "conflict" {
+		t.Errorf("patch to a taken key: got %+v", results)
+	}
+
+	req := httptest.NewRequest("POST", "/items/import/csv", strings.NewReader("id,name,age\n5,Carol,1\n6,Carol,1\n"))
+	req.Header.Set("Content-Type", "text/csv")
+	rr = httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("import repeating a key: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+
+	if got := len(server.store.List()); got != 2 {
+		t.Errorf("rejected writes changed the store: %d items, want 2", got)
+	}
+}
+
+// TestEnableCompositeKeysWithDuplicates checks that composite-key mode can't be
+// switched on over items that already share a key.
+func TestEnableCompositeKeysWithDuplicates(t *testing.T) {
+	server := newServer()
+	server.putItem(Item{ID: 1, Name: "Twin", Age: 5})
+	server.putItem(Item{ID: 2, Name: "Twin", Age: 5})
+
+	if err := server.enableCompositeKeys(); err == nil {
+		t.Error("expected an error for items sharing a key, got none")
+	}
+
+	// Outside composite-key mode, the lookup isn't available.
+	if rr := sendTo(server, "GET", "/items/by-key?name=Twin&age=5", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("lookup without composite keys: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
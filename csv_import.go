@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// csvHeader is the column layout expected by the CSV import. The header row
+// itself is optional.
+var csvHeader = []string{"id", "name", "age"}
+
+// csvRow is a single record read from an import, along with the line it came
+// from so errors can point the client at it.
+type csvRow struct {
+	line   int
+	record []string
+}
+
+// handleImportCSV handles requests to import many items at once from a CSV
+// document (e.g., POST /items/import/csv with a text/csv body). The import is
+// all-or-nothing: every row is parsed and checked first, and the datastore is
+// only touched once the whole file is known to be good.
+func (s *server) handleImportCSV() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType != "text/csv" {
+			writeJSONError(w, http.StatusUnsupportedMediaType, "Unsupported media type: expected text/csv")
+			return
+		}
+
+		// Read all the rows before taking the lock, so a slow client doesn't
+		// hold up everyone else.
+		rows, err := readCSVRows(r.Body)
+		if err != nil {
+			s.reqLogf(r, "ERROR importing CSV: %v", err)
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Bad request: %v", err))
+			return
+		}
+
+		// Turning rows into items doesn't touch the datastore, so it can be
+		// spread across workers without any locking.
+		items, err := parseCSVRows(rows, s.importWorkers)
+		if err != nil {
+			s.reqLogf(r, "ERROR importing CSV: %v", err)
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Bad request: %v", err))
+			return
+		}
+
+		// Checking the IDs and inserting the rows happen under one write lock,
+		// which is what makes the import all-or-nothing.
+		s.mu.Lock()
+		if err := s.checkImportIDs(rows, items); err != nil {
+			s.mu.Unlock()
+			s.reqLogf(r, "ERROR importing CSV: %v", err)
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Bad request: %v", err))
+			return
+		}
+		// Every row checked out, so stamp them and store them all. If the
+		// datastore fails partway, the rows already stored are taken back out,
+		// so a failed import still leaves nothing behind.
+		now, principal := s.now(), principalFrom(r.Context())
+		wasDirty := s.dirty.Load()
+		for i, item := range items {
+			item.CreatedAt, item.UpdatedAt = now, now
+			item.ModifiedBy = principal
+			if err := s.putItem(item); err != nil {
+				for _, stored := range items[:i] {
+					s.discardItem(stored, wasDirty)
+				}
+				s.mu.Unlock()
+				s.writeStoreError(w, r, err)
+				return
+			}
+		}
+		s.mu.Unlock()
+		s.reqLogf(r, "Imported %d item(s) from CSV", len(items))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]int{"imported": len(items)})
+	}
+}
+
+// readCSVRows reads every id,name,age record from body, skipping the header
+// row if there is one.
+func readCSVRows(body io.Reader) ([]csvRow, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = len(csvHeader)
+	reader.TrimLeadingSpace = true
+
+	var rows []csvRow
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				return nil, fmt.Errorf("row %d: %v", parseErr.Line, parseErr.Err)
+			}
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+
+		// Skip the header row, if the file has one.
+		if first && strings.EqualFold(record[0], csvHeader[0]) {
+			continue
+		}
+		rows = append(rows, csvRow{line: line, record: record})
+	}
+
+	if len(rows) == 0 {
+		return nil, errors.New("no rows to import")
+	}
+	return rows, nil
+}
+
+// parseCSVRows turns rows into validated items using up to workers goroutines.
+// If any rows are bad, the error for the first of them (by position in the
+// file) is returned, so the result doesn't depend on which worker got there first.
+func parseCSVRows(rows []csvRow, workers int) ([]Item, error) {
+	items := make([]Item, len(rows))
+	errs := make([]error, len(rows))
+
+	// Hand out row indexes to the workers; each one writes only to its own
+	// slots in items and errs, so no locking is needed.
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < max(workers, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				items[i], errs[i] = parseCSVRecord(rows[i].record)
+			}
+		}()
+	}
+	for i := range rows {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %v", rows[i].line, err)
+		}
+	}
+	return items, nil
+}
+
+// checkImportIDs makes sure no imported item reuses an ID, whether one that's
+// already in the datastore or one from earlier in the same file, and the same
+// for composite keys in composite-key mode. s.mu must be held for reading at least.
+func (s *server) checkImportIDs(rows []csvRow, items []Item) error {
+	seen := make(map[int]bool, len(items))
+	seenKeys := make(map[compositeKey]bool, len(items))
+	for i, item := range items {
+		if _, found := s.store.Get(item.ID); found || seen[item.ID] {
+			return fmt.Errorf("row %d: ID %d already in use", rows[i].line, item.ID)
+		}
+		seen[item.ID] = true
+		// In composite-key mode, the name and age have to be free as well.
+		if s.byKey != nil {
+			if err := s.checkKey(item); err != nil {
+				return fmt.Errorf("row %d: %v", rows[i].line, err)
+			}
+			if seenKeys[keyOf(item)] {
+				return fmt.Errorf("row %d: name %q and age %d already used earlier in the file", rows[i].line, item.Name, item.Age)
+			}
+			seenKeys[keyOf(item)] = true
+		}
+	}
+	return nil
+}
+
+// parseCSVRecord turns a single id,name,age record into a valid Item.
+func parseCSVRecord(record []string) (Item, error) {
+	id, err := strconv.Atoi(record[0])
+	if err != nil {
+		return Item{}, fmt.Errorf("invalid id %q", record[0])
+	}
+	// Elsewhere an ID of 0 means "pick one for me", so no item can have it.
+	if id == 0 {
+		return Item{}, errors.New("id must not be 0")
+	}
+	age, err := strconv.Atoi(record[2])
+	if err != nil {
+		return Item{}, fmt.Errorf("invalid age %q", record[2])
+	}
+	item := Item{ID: id, Name: record[1], Age: age}
+	return item, item.Validate()
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestHandleImportCSV checks that a clean CSV file is imported in full.
+func TestHandleImportCSV(t *testing.T) {
+	server := newServer()
+
+	body := "id,name,age\n1,Alice,30\n2,Bob,40\n"
+	req := httptest.NewRequest("POST", "/items/import/csv", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/csv")
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusCreated)
+	}
+	if len(server.store.List()) != 2 || storedItem(server, 2).content() != (Item{ID: 2, Name: "Bob", Age: 40}) {
+		t.Errorf("unexpected datastore after import: %+v", server.store.List())
+	}
+}
+
+// TestHandleImportCSVBadRow checks that a single bad row aborts the whole
+// import, leaves the datastore untouched, and is reported by its row number.
+func TestHandleImportCSVBadRow(t *testing.T) {
+	server := newServer()
+
+	// Row 3 has an age that isn't a number.
+	body := "id,name,age\n1,Alice,30\n2,Bob,forty\n3,Carol,50\n"
+	req := httptest.NewRequest("POST", "/items/import/csv", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/csv")
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	if !strings.Contains(rr.Body.String(), "row 3") {
+		t.Errorf("error does not name the bad row: %q", rr.Body.String())
+	}
+	if len(server.store.List()) != 0 {
+		t.Errorf("import was not all-or-nothing, datastore has %d item(s)", len(server.store.List()))
+	}
+}
+
+// TestHandleImportCSVZeroID checks that a row with ID 0, which no other
+// endpoint can create or address, is rejected like any other bad row.
+func TestHandleImportCSVZeroID(t *testing.T) {
+	server := newServer()
+
+	body := "1,Alice,30\n0,Bob,40\n"
+	req := httptest.NewRequest("POST", "/items/import/csv", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/csv")
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	if !strings.Contains(rr.Body.String(), "row 2: id must not be 0") {
+		t.Errorf("error does not name the bad row: %q", rr.Body.String())
+	}
+	if len(server.store.List()) != 0 {
+		t.Errorf("import was not all-or-nothing, datastore has %d item(s)", len(server.store.List()))
+	}
+}
+
+// TestHandleImportCSVParallel imports a large file with several validation
+// workers and checks every row lands intact, and that with several bad rows
+// the first one in the file is the one reported.
+func TestHandleImportCSVParallel(t *testing.T) {
+	server := newServer()
+	server.importWorkers = 8
+
+	var body strings.Builder
+	body.WriteString("id,name,age\n")
+	for id := 1; id <= 1000; id++ {
+		fmt.Fprintf(&body, "%d,Item %d,%d\n", id, id, id%100)
+	}
+	req := httptest.NewRequest("POST", "/items/import/csv", strings.NewReader(body.String()))
+	req.Header.Set("Content-Type", "text/csv")
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusCreated)
+	}
+	if got := len(server.store.List()); got != 1000 {
+		t.Fatalf("datastore holds %d items, want 1000", got)
+	}
+	for id := 1; id <= 1000; id++ {
+		want := Item{ID: id, Name: fmt.Sprintf("Item %d", id), Age: id % 100}
+		if got := storedItem(server, id); got.content() != want {
+			t.Fatalf("item %d: got %+v want %+v", id, got, want)
+		}
+	}
+
+	// Rows 500 and 900 are both bad; the error must name row 500 every time.
+	body.Reset()
+	body.WriteString("id,name,age\n")
+	for id := 2001; id <= 3000; id++ {
+		age := strconv.Itoa(id)
+		if id == 2499 || id == 2899 {
+			age = "bad"
+		}
+		fmt.Fprintf(&body, "%d,Item,%s\n", id, age)
+	}
+	req = httptest.NewRequest("POST", "/items/import/csv", strings.NewReader(body.String()))
+	req.Header.Set("Content-Type", "text/csv")
+	rr = httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+
+	if !strings.Contains(rr.Body.String(), "row 500:") {
+		t.Errorf("error does not name the first bad row: %q", rr.Body.String())
+	}
+	if got := len(server.store.List()); got != 1000 {
+		t.Errorf("failed import changed the datastore to %d items", got)
+	}
+}
+
+// TestHandleImportCSVStoreError checks that when the datastore fails partway
+// through an import, the rows stored before the failure are taken back out.
+func TestHandleImportCSVStoreError(t *testing.T) {
+	server := newServer()
+	server.store = &failingStore{memStore: server.store.(*memStore), failID: 3}
+
+	body := "id,name,age\n1,Alice,30\n2,Bob,40\n3,Carol,50\n"
+	req := httptest.NewRequest("POST", "/items/import/csv", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/csv")
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusInternalServerError {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusInternalServerError)
+	}
+	if n := server.store.Len(); n != 0 {
+		t.Errorf("expected a failed import to store nothing, got %d item(s)", n)
+	}
+	if counts := server.metrics.flush(server.now()).Counters; counts["items_created"] != 0 {
+		t.Errorf("expected no creates counted, got %d", counts["items_created"])
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// trackInFlight is a middleware that keeps s.inFlight up to date with the
+// number of requests currently being served.
+func (s *server) trackInFlight(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.inFlight.Add(1)
+		defer s.inFlight.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// closeWhenDraining is a middleware that adds Connection: close to every
+// response once shutdown has begun, so keep-alive clients open their next
+// connection elsewhere instead of reusing one that's about to go away.
+// net/http sees the header and closes the connection after the response.
+func (s *server) closeWhenDraining(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.draining.Load() {
+			w.Header().Set("Connection", "close")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// drainStatus is the body returned by GET /admin/draining.
+type drainStatus struct {
+	Draining bool  `json:"draining"`
+	InFlight int64 `json:"in_flight"`
+}
+
+// handleDraining handles requests for the shutdown drain progress (e.g., GET
+// /admin/draining). It only reads two atomic values, so it never waits on the
+// requests it's reporting about.
+func (s *server) handleDraining() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := drainStatus{
+			Draining: s.draining.Load(),
+			// When served through the main router this request is counted
+			// too, but it isn't one of the requests being drained.
+			InFlight: max(s.inFlight.Load()-1, 0),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	}
+}
+
+// shutdown stops srv, giving active requests until ctx is done to finish,
+// then stops the flush loop (stopFlushing, waiting for flushDone) and saves
+// the datastore one last time. Requests still running when ctx runs out are
+// cut off, and the datastore is saved anyway, so a slow request can't cost
+// every unsaved change. The error is srv.Shutdown's, for the caller to exit on.
+func (s *server) shutdown(ctx context.Context, srv *http.Server, stopFlushing func(), flushDone <-chan struct{}) error {
+	err := srv.Shutdown(ctx)
+	if err != nil {
+		s.logf("ERROR server forced to shutdown: %v", err)
+		// Cut off the requests that wouldn't finish, so they stop changing
+		// the datastore before it's saved.
+		srv.Close()
+	}
+	// No more requests can change the datastore now, so it's safe to save.
+	// The flush loop is stopped first so an older copy can't land on top.
+	stopFlushing()
+	<-flushDone
+	if s.dataFile != "" {
+		if err := s.saveToFile(s.dataFile); err != nil {
+			s.logf("ERROR saving data to %s: %v", s.dataFile, err)
+		} else {
+			s.logf("Saved data to %s", s.dataFile)
+		}
+	}
+	// A database-backed store gets closed, so everything it wrote is on disk.
+	if closer, ok := s.store.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			s.logf("ERROR closing datastore: %v", err)
+		}
+	}
+	return err
+}
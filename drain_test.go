@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestHandleDraining starts a request that blocks, begins draining, and checks
+// that the drain status reports it as still in flight.
+func TestHandleDraining(t *testing.T) {
+	server := newServer()
+
+	// A stand-in for /slow that blocks until we let it go.
+	started, release := make(chan struct{}), make(chan struct{})
+	err := server.register(route{http.MethodGet, "/block", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}})
+	if err != nil {
+		t.Fatalf("could not register blocking route: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		server.router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/block", nil))
+		close(done)
+	}()
+	<-started
+	server.draining.Store(true)
+
+	status := func() drainStatus {
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/draining", nil))
+		var got drainStatus
+		if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+			t.Fatalf("could not decode response body: %v", err)
+		}
+		return got
+	}
+
+	// The status request must answer while the other request is still blocked.
+	if got, want := status(), (drainStatus{Draining: true, InFlight: 1}); got != want {
+		t.Errorf("during drain: got %+v want %+v", got, want)
+	}
+
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("blocked request did not finish")
+	}
+	if got, want := status(), (drainStatus{Draining: true, InFlight: 0}); got != want {
+		t.Errorf("after drain: got %+v want %+v", got, want)
+	}
+}
+
+// TestCloseWhenDraining checks that responses carry Connection: close once
+// draining has begun, and only then.
+func TestCloseWhenDraining(t *testing.T) {
+	server := newServer()
+	ts := httptest.NewServer(server.router)
+	defer ts.Close()
+
+	get := func() *http.Response {
+		t.Helper()
+		resp, err := http.Get(ts.URL + "/healthz")
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	if resp := get(); resp.Close {
+		t.Error("connection closed before draining began")
+	}
+
+	server.draining.Store(true)
+	resp := get()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", resp.StatusCode, http.StatusOK)
+	}
+	// The client moves the Connection: close header into resp.Close.
+	if !resp.Close {
+		t.Error("expected Connection: close on a response while draining")
+	}
+}
+
+// TestShutdownTimeoutStillSaves shuts down while a request is stuck past the
+// deadline, and checks the error is reported but the data file is saved anyway.
+func TestShutdownTimeoutStillSaves(t *testing.T) {
+	server := newServer()
+	server.dataFile = filepath.Join(t.TempDir(), "data.json")
+	server.putItem(Item{ID: 1, Name: "Alice", Age: 30})
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	go srv.Serve(ln)
+	go http.Get("http://" + ln.Addr().String())
+	<-started
+
+	flushDone := make(chan struct{})
+	stopped := false
+	stopFlushing := func() { stopped = true; close(flushDone) }
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := server.shutdown(ctx, srv, stopFlushing, flushDone); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v want %v", err, context.DeadlineExceeded)
+	}
+	if !stopped {
+		t.Errorf("flush loop was not stopped")
+	}
+
+	fresh := newServer()
+	if err := fresh.loadFromFile(server.dataFile); err != nil {
+		t.Fatalf("data file not saved: %v", err)
+	}
+	if got := storedItem(fresh, 1); got.Name != "Alice" {
+		t.Errorf("saved item 1 is %+v, want Alice", got)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// itemETag returns the entity tag for an item's JSON encoding body. By default
+// it's a strong tag, promising the body is byte-for-byte what the client has.
+// With -weak-etags it's a weak one (W/"..."), promising only that the item is
+// the same, which leaves the server free to change how it encodes items
+// without every cache treating them as new.
+func (s *server) itemETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	tag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	if s.weakETags {
+		return "W/" + tag
+	}
+	return tag
+}
+
+// etagMatchesNoneOf reports whether r's If-None-Match header matches etag,
+// meaning the client's copy is current. If-None-Match always uses the weak
+// comparison (RFC 9110, section 13.1.2), so W/"x" and "x" match each other
+// whichever kind the server hands out; "*" matches any existing item.
+func etagMatchesNoneOf(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for _, tag := range parseETagList(header) {
+		if weakETagMatch(tag, etag) {
+			return true
+		}
+	}
+	return false
+}
+
+// weakETagMatch reports whether a and b match by the weak comparison: their
+// opaque tags are the same, whether or not either is marked weak.
+func weakETagMatch(a, b string) bool {
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+// parseETagList splits a header like `"a", W/"b"` into its entity tags. Tags
+// are quoted and may themselves contain commas, so the header is scanned
+// rather than split on them. Parsing stops at the first malformed entry, as
+// a tag after it can't be picked out reliably.
+func parseETagList(header string) []string {
+	var tags []string
+	for rest := header; ; {
+		rest = strings.TrimLeft(rest, " \t,")
+		if rest == "" {
+			return tags
+		}
+		// Only an upper-case W/ marks a weak tag; anything else is malformed.
+		prefix := ""
+		if strings.HasPrefix(rest, "W/") {
+			prefix, rest = "W/", rest[2:]
+		}
+		if !strings.HasPrefix(rest, `"`) {
+			return tags
+		}
+		end := strings.IndexByte(rest[1:], '"')
+		if end < 0 {
+			return tags
+		}
+		tags = append(tags, prefix+rest[:end+2])
+		rest = rest[end+2:]
+	}
+}
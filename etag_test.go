@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestHandleGetItemETag checks GET /items/{id} answers If-None-Match with a
+// 304 exactly when the client's tag matches by the weak comparison, with both
+// strong and weak ETags.
+func TestHandleGetItemETag(t *testing.T) {
+	for _, weak := range []bool{false, true} {
+		server := newServer()
+		server.weakETags = weak
+		server.putItem(Item{ID: 1, Name: "Alice", Age: 30})
+
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, httptest.NewRequest("GET", "/items/1", nil))
+		etag := rr.Header().Get("ETag")
+		if strings.HasPrefix(etag, "W/") != weak || !strings.HasSuffix(etag, `"`) {
+			t.Fatalf("weak=%v: got ETag %q", weak, etag)
+		}
+		opaque := strings.TrimPrefix(etag, "W/")
+
+		tests := []struct {
+			name        string
+			ifNoneMatch string
+			want        int
+		}{
+			{"no header", "", http.StatusOK},
+			{"same tag", etag, http.StatusNotModified},
+			{"weak form of the tag", "W/" + opaque, http.StatusNotModified},
+			{"strong form of the tag", opaque, http.StatusNotModified},
+			{"in a list", `"other", ` + etag + `, W/"another"`, http.StatusNotModified},
+			{"list without spaces", `"other",` + etag, http.StatusNotModified},
+			{"star", "*", http.StatusNotModified},
+			{"different tag", `"0123456789abcdef"`, http.StatusOK},
+			{"different weak tag", `W/"0123456789abcdef"`, http.StatusOK},
+			{"lower-case weak marker", "w/" + opaque, http.StatusOK},
+			{"unquoted", strings.Trim(opaque, `"`), http.StatusOK},
+		}
+		for _, tt := range tests {
+			req := httptest.NewRequest("GET", "/items/1", nil)
+			if tt.ifNoneMatch != "" {
+				req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			rr := httptest.NewRecorder()
+			server.router.ServeHTTP(rr, req)
+			if rr.Code != tt.want {
+				t.Errorf("weak=%v, %s: handler returned wrong status code: got %v want %v", weak, tt.name, rr.Code, tt.want)
+			}
+			if got := rr.Header().Get("ETag"); got != etag {
+				t.Errorf("weak=%v, %s: got ETag %q want %q", weak, tt.name, got, etag)
+			}
+			if tt.want == http.StatusNotModified && rr.Body.Len() != 0 {
+				t.Errorf("weak=%v, %s: 304 came with a body: %q", weak, tt.name, rr.Body.String())
+			}
+		}
+
+		// Changing the item changes its tag, so the old one no longer matches.
+		server.putItem(Item{ID: 1, Name: "Alice", Age: 31})
+		req := httptest.NewRequest("GET", "/items/1", nil)
+		req.Header.Set("If-None-Match", etag)
+		rr = httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK || rr.Header().Get("ETag") == etag {
+			t.Errorf("weak=%v, after an update: got %v with ETag %q", weak, rr.Code, rr.Header().Get("ETag"))
+		}
+	}
+}
+
+// TestHandleGetItemETagMissing checks "*" doesn't make a missing item look
+// current.
+func TestHandleGetItemETagMissing(t *testing.T) {
+	server := newServer()
+	req := httptest.NewRequest("GET", "/items/1", nil)
+	req.Header.Set("If-None-Match", "*")
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+	}
+}
+
+// TestParseETagList checks entity tags are picked out of If-None-Match,
+// including ones containing commas, and that parsing stops at a bad entry.
+func TestParseETagList(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []string
+	}{
+		{`"a"`, []string{`"a"`}},
+		{`"a", W/"b"`, []string{`"a"`, `W/"b"`}},
+		{`"a,b" , "c"`, []string{`"a,b"`, `"c"`}},
+		{`W/""`, []string{`W/""`}},
+		{`"a", bogus, "c"`, []string{`"a"`}},
+		{`"unterminated`, nil},
+	}
+	for _, tt := range tests {
+		if got := parseETagList(tt.header); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseETagList(%q): got %q want %q", tt.header, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var errInvalidMinAge = errors.New("invalid min_age")
+
+// itemFilter holds the filters a client can put on a listing, read from the
+// query string. The zero value matches every item.
+type itemFilter struct {
+	minAge     int    // Only items at least this old (min_age).
+	name       string // Only items whose name contains this, ignoring case (name), lower-cased.
+	modifiedBy string // Only items last changed by exactly this principal (modified_by).
+}
+
+// parseItemFilter reads the filters from the request's query string.
+func parseItemFilter(r *http.Request) (itemFilter, error) {
+	minAge, err := queryInt(r, "min_age", 0)
+	if err != nil {
+		return itemFilter{}, errInvalidMinAge
+	}
+	return itemFilter{
+		minAge:     minAge,
+		name:       strings.ToLower(r.URL.Query().Get("name")),
+		modifiedBy: r.URL.Query().Get("modified_by"),
+	}, nil
+}
+
+// matches reports whether item passes every filter.
+func (f itemFilter) matches(item Item) bool {
+	if item.Age < f.minAge {
+		return false
+	}
+	if f.modifiedBy != "" && item.ModifiedBy != f.modifiedBy {
+		return false
+	}
+	return f.name == "" || strings.Contains(strings.ToLower(item.Name), f.name)
+}
+
+// apply returns the items that pass every filter, in their original order.
+func (f itemFilter) apply(items []Item) []Item {
+	matching := make([]Item, 0, len(items))
+	for _, item := range items {
+		if f.matches(item) {
+			matching = append(matching, item)
+		}
+	}
+	return matching
+}
+
+// itemSortFields are the fields a listing can be sorted by, each with a
+// function comparing two items on it. Names compare ignoring case, like the
+// name search.
+var itemSortFields = map[string]func(a, b Item) int{
+	"id":         func(a, b Item) int { return cmp.Compare(a.ID, b.ID) },
+	"name":       func(a, b Item) int { return cmp.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)) },
+	"age":        func(a, b Item) int { return cmp.Compare(a.Age, b.Age) },
+	"position":   func(a, b Item) int { return cmp.Compare(a.Position, b.Position) },
+	"created_at": func(a, b Item) int { return a.CreatedAt.Compare(b.CreatedAt) },
+}
+
+// itemSort is the order a client asked for with the sort query parameter, or
+// the deployment picked with -default-sort: a field name, optionally prefixed
+// with "-" for descending. The zero value leaves the items in the order they
+// came in.
+type itemSort struct {
+	field string
+	desc  bool
+}
+
+// parseItemSort reads the sort query parameter, rejecting fields items can't
+// be sorted by. Without one, the order is def.
+func parseItemSort(r *http.Request, def itemSort) (itemSort, error) {
+	v := r.URL.Query().Get("sort")
+	if v == "" {
+		return def, nil
+	}
+	return parseSortSpec(v)
+}
+
+// parseSortSpec parses an order like "name" or "-created_at".
+func parseSortSpec(v string) (itemSort, error) {
+	field, desc := strings.CutPrefix(v, "-")
+	if _, ok := itemSortFields[field]; !ok {
+		return itemSort{}, fmt.Errorf("invalid sort field %q: want id, name, age, position or created_at", field)
+	}
+	return itemSort{field: field, desc: desc}, nil
+}
+
+// apply sorts items in place. The sort is stable, so items that tie keep the
+// order they came in, which is by ID unless specific IDs were asked for.
+func (o itemSort) apply(items []Item) {
+	compare, ok := itemSortFields[o.field]
+	if !ok {
+		return
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		c := compare(items[i], items[j])
+		if o.desc {
+			return c > 0
+		}
+		return c < 0
+	})
+}
+
+// parseIDList parses a comma-separated list of item IDs, like "1,2,3". Each ID
+// appears once in the result, in the order it was first given.
+func parseIDList(v string) ([]int, error) {
+	var ids []int
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(v, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("invalid id %q in ids", part)
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// pickIDs returns the items with the given IDs, in the order of ids, along
+// with the IDs that none of the items have.
+func pickIDs(items []Item, ids []int) (picked []Item, missing []int) {
+	byID := make(map[int]Item, len(items))
+	for _, item := range items {
+		byID[item.ID] = item
+	}
+	picked = make([]Item, 0, len(ids))
+	for _, id := range ids {
+		if item, found := byID[id]; found {
+			picked = append(picked, item)
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	return picked, missing
+}
+
+// joinInts formats ints as a comma-separated list, like "1,2,3".
+func joinInts(ints []int) string {
+	parts := make([]string, len(ints))
+	for i, n := range ints {
+		parts[i] = strconv.Itoa(n)
+	}
+	return strings.Join(parts, ",")
+}
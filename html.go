@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+)
+
+// Paging defaults for the HTML item table and the JSON list.
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// itemsTable renders a page of items as a plain HTML table. html/template
+// escapes every value it prints, so item names can't inject markup.
+var itemsTable = template.Must(template.New("items").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Items</title></head>
+<body>
+<table>
+<tr><th>ID</th><th>Name</th><th>Age</th></tr>
+{{- range .Items}}
+<tr><td>{{.ID}}</td><td>{{.Name}}</td><td>{{.Age}}</td></tr>
+{{- end}}
+</table>
+<p>Showing {{len .Items}} of {{.Total}} item(s).
+{{- if .HasPrev}} <a href="?limit={{.Limit}}&offset={{.PrevOffset}}">Previous</a>{{end}}
+{{- if .HasNext}} <a href="?limit={{.Limit}}&offset={{.NextOffset}}">Next</a>{{end}}</p>
+</body>
+</html>
+`))
+
+// itemsPage is the data handed to the itemsTable template.
+type itemsPage struct {
+	Items                  []Item
+	Total, Limit           int
+	HasPrev, HasNext       bool
+	PrevOffset, NextOffset int
+}
+
+// handleItemsHTML handles requests to browse the stored items as an HTML table,
+// ordered by ID and split into pages (e.g., GET /items.html?limit=20&offset=40).
+func (s *server) handleItemsHTML() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, offset, err := parsePaging(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Bad request: %v", err))
+			return
+		}
+
+		s.mu.RLock()
+		items := s.sortedItems()
+		s.mu.RUnlock()
+		page := itemsPage{
+			Items:      page(items, limit, offset),
+			Total:      len(items),
+			Limit:      limit,
+			HasPrev:    offset > 0,
+			HasNext:    offset+limit < len(items),
+			PrevOffset: max(offset-limit, 0),
+			NextOffset: offset + limit,
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := itemsTable.Execute(w, page); err != nil {
+			s.reqLogf(r, "ERROR rendering items table: %v", err)
+		}
+	}
+}
+
+// parsePaging reads the limit and offset query parameters of a paged listing.
+// A limit above maxPageLimit is brought down to it.
+func parsePaging(r *http.Request) (limit, offset int, err error) {
+	limit, err = queryInt(r, "limit", defaultPageLimit)
+	if err != nil || limit < 1 {
+		return 0, 0, errors.New("invalid limit")
+	}
+	offset, err = queryInt(r, "offset", 0)
+	if err != nil || offset < 0 {
+		return 0, 0, errors.New("invalid offset")
+	}
+	return min(limit, maxPageLimit), offset, nil
+}
+
+// page returns the items in [offset, offset+limit), clamped to the slice.
+func page(items []Item, limit, offset int) []Item {
+	return items[min(offset, len(items)):min(offset+limit, len(items))]
+}
+
+// queryInt reads an integer query parameter, returning def when it's absent.
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	return strconv.Atoi(v)
+}
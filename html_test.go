@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestHandleItemsHTML checks that the HTML table lists the items and escapes
+// any markup in their names.
+func TestHandleItemsHTML(t *testing.T) {
+	server := newServer()
+	server.putItem(Item{ID: 1, Name: "Alice", Age: 30})
+	server.putItem(Item{ID: 2, Name: "<script>alert(1)</script>", Age: 40})
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("GET", "/items.html", nil))
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("got Content-Type %q, want text/html", ct)
+	}
+
+	body := rr.Body.String()
+	if !strings.Contains(body, "<td>1</td><td>Alice</td><td>30</td>") {
+		t.Errorf("table is missing Alice's row:\n%s", body)
+	}
+	if strings.Contains(body, "<script>") {
+		t.Errorf("name was not escaped:\n%s", body)
+	}
+	if !strings.Contains(body, "&lt;script&gt;") {
+		t.Errorf("escaped name is missing:\n%s", body)
+	}
+}
+
+// TestHandleItemsHTMLPaging checks that limit and offset select a page of rows.
+func TestHandleItemsHTMLPaging(t *testing.T) {
+	server := newServer()
+	for id := 1; id <= 5; id++ {
+		server.putItem(Item{ID: id, Name: "Item", Age: id})
+	}
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("GET", "/items.html?limit=2&offset=2", nil))
+
+	body := rr.Body.String()
+	if got := strings.Count(body, "<tr><td>"); got != 2 {
+		t.Errorf("got %d rows, want 2:\n%s", got, body)
+	}
+	if !strings.Contains(body, "<td>3</td>") || !strings.Contains(body, "<td>4</td>") {
+		t.Errorf("page does not hold items 3 and 4:\n%s", body)
+	}
+}
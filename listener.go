@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// listen opens the main listener on addr. network picks the IP stack: "tcp"
+// lets the platform decide (usually both IPv4 and IPv6 on ":8080"), while
+// "tcp4" and "tcp6" force one or the other.
+func listen(network, addr string) (net.Listener, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("unsupported network %q: want tcp, tcp4 or tcp6", network)
+	}
+	return net.Listen(network, addr)
+}
+
+// addrFamily reports which IP family a listener ended up on, for the startup
+// log. An unspecified address on "tcp" shows up as IPv6 on dual-stack
+// platforms, since that socket accepts both.
+func addrFamily(addr net.Addr) string {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return addr.Network()
+	}
+	if tcpAddr.IP.To4() != nil {
+		return "IPv4"
+	}
+	return "IPv6"
+}
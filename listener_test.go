@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+)
+
+// TestListenTCP4 checks that forcing tcp4 really binds an IPv4 address.
+func TestListenTCP4(t *testing.T) {
+	ln, err := listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	if got := addrFamily(ln.Addr()); got != "IPv4" {
+		t.Errorf("wrong address family: got %q want IPv4 (addr %v)", got, ln.Addr())
+	}
+}
+
+// TestListenTCP6 checks that forcing tcp6 binds an IPv6 address. Not every
+// sandbox has IPv6, so the test is skipped when the loopback can't be bound.
+func TestListenTCP6(t *testing.T) {
+	ln, err := listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback not available: %v", err)
+	}
+	defer ln.Close()
+
+	if got := addrFamily(ln.Addr()); got != "IPv6" {
+		t.Errorf("wrong address family: got %q want IPv6 (addr %v)", got, ln.Addr())
+	}
+}
+
+// TestListenRejectsUnknownNetwork checks that only the TCP networks are accepted.
+func TestListenRejectsUnknownNetwork(t *testing.T) {
+	if ln, err := listen("udp", "127.0.0.1:0"); err == nil {
+		ln.Close()
+		t.Error("expected an error for network udp, got none")
+	}
+}
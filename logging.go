@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// logLevels are the names PUT /admin/log-level accepts.
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// jsonLogsFromEnv reports whether LOG_FORMAT asks for JSON log lines. Anything
+// else, including leaving it unset, keeps the plain-text log.
+func jsonLogsFromEnv() bool {
+	return strings.EqualFold(os.Getenv("LOG_FORMAT"), "json")
+}
+
+// newJSONLogger returns a logger writing one JSON object per line to w, with
+// the level and msg fields plus whatever request fields were attached. Lines
+// below level are dropped; level is read on every line, so changes to it take
+// effect straight away.
+func newJSONLogger(w io.Writer, level slog.Leveler) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level}))
+}
+
+// logf formats and logs a message. Every part of the server logs through here
+// (or reqLogf and logRequest, for messages about a request), so the output
+// format is decided in one place: plain text through s.logger by default,
+// JSON lines through s.jsonLog when it's set.
+//
+// Messages keep the "ERROR ..." and "WARNING ..." prefixes they've always had,
+// and those prefixes set the level of the JSON line.
+func (s *server) logf(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	s.logAttrs(levelOf(msg), msg)
+}
+
+// reqLogf is logf for a message logged while serving r, so the line carries
+// r's request ID.
+func (s *server) reqLogf(r *http.Request, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	s.logForRequest(r.Context(), levelOf(msg), msg)
+}
+
+// logRequest logs a message about a single request, so that JSON lines carry
+// its method, path and duration as separate fields. A status of 0 means no
+// status is known (say, because the client went away first) and is left out.
+func (s *server) logRequest(ctx context.Context, method, path string, status int, elapsed time.Duration, msg string) {
+	attrs := []slog.Attr{slog.String("method", method), slog.String("path", path)}
+	if status != 0 {
+		attrs = append(attrs, slog.Int("status", status))
+	}
+	attrs = append(attrs, slog.Float64("duration_ms", float64(elapsed)/float64(time.Millisecond)))
+	s.logForRequest(ctx, levelOf(msg), msg, attrs...)
+}
+
+// logForRequest writes a log line about the request ctx belongs to, tagged
+// with its request ID: a request_id field in JSON, and a "[id]" in front of
+// the message in plain text. The level is worked out before the tag is added,
+// so the "ERROR" prefix still counts.
+func (s *server) logForRequest(ctx context.Context, level slog.Level, msg string, attrs ...slog.Attr) {
+	if id := requestIDFromContext(ctx); id != "" {
+		if s.jsonLog != nil {
+			attrs = append(attrs, slog.String("request_id", id))
+		} else {
+			msg = "[" + id + "] " + msg
+		}
+	}
+	s.logAttrs(level, msg, attrs...)
+}
+
+// debugf formats and logs a message at debug level, which is hidden unless
+// the log level has been turned down to debug.
+func (s *server) debugf(format string, args ...any) {
+	s.logAttrs(slog.LevelDebug, fmt.Sprintf(format, args...))
+}
+
+// fatalf logs a message like logf and then exits with status code 1.
+func (s *server) fatalf(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	s.logAttrs(slog.LevelError, msg)
+	os.Exit(1)
+}
+
+// logAttrs writes a log line in the configured format. The plain-text log has
+// no room for the extra fields, so callers put anything important in msg too.
+func (s *server) logAttrs(level slog.Level, msg string, attrs ...slog.Attr) {
+	if s.jsonLog == nil {
+		// The JSON handler does its own level check; the text log needs ours.
+		if level >= s.logLevel.Level() {
+			s.logger.Print(msg)
+		}
+		return
+	}
+	s.jsonLog.LogAttrs(context.Background(), level, msg, attrs...)
+}
+
+// levelOf picks the level of a message from its prefix.
+func levelOf(msg string) slog.Level {
+	switch {
+	case strings.HasPrefix(msg, "ERROR"):
+		return slog.LevelError
+	case strings.HasPrefix(msg, "WARNING"):
+		return slog.LevelWarn
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// handleSetLogLevel handles requests to change how much gets logged without a
+// restart (e.g., PUT /admin/log-level with {"level":"debug"}). The level is
+// one of debug, info, warn or error.
+func (s *server) handleSetLogLevel() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Level string `json:"level"`
+		}
+		if err := s.decodeJSON(r, &req); err != nil {
+			s.reqLogf(r, "ERROR decoding request body: %v", err)
+			writeJSONError(w, http.StatusBadRequest, decodeErrorMessage(err))
+			return
+		}
+		name := strings.ToLower(req.Level)
+		level, ok := logLevels[name]
+		if !ok {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Bad request: unknown log level %q, want debug, info, warn or error", req.Level))
+			return
+		}
+
+		// Log the change at the old level, before switching, so it's never
+		// filtered out whichever way the level moves.
+		old := s.logLevel.Level()
+		s.logAttrs(old, fmt.Sprintf("Log level changed from %s to %s", strings.ToLower(old.String()), name))
+		s.logLevel.Set(level)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"level": name})
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestLogfJSON checks that JSON log lines carry the level and message, with
+// the level taken from the message's prefix.
+func TestLogfJSON(t *testing.T) {
+	s := newServer()
+	var logs bytes.Buffer
+	s.jsonLog = newJSONLogger(&logs, &s.logLevel)
+
+	s.logf("Imported %d item(s) from CSV", 3)
+	s.logf("ERROR saving data to %s: %v", "data.json", "disk full")
+
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 log lines, got %d: %q", len(lines), logs.String())
+	}
+	want := []struct{ level, msg string }{
+		{"INFO", "Imported 3 item(s) from CSV"},
+		{"ERROR", "ERROR saving data to data.json: disk full"},
+	}
+	for i, line := range lines {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("log line %q is not JSON: %v", line, err)
+		}
+		if entry["level"] != want[i].level || entry["msg"] != want[i].msg {
+			t.Errorf("line %d: got level %v msg %q, want level %v msg %q",
+				i, entry["level"], entry["msg"], want[i].level, want[i].msg)
+		}
+	}
+}
+
+// TestLogRequestJSON checks that request log lines carry the request fields.
+func TestLogRequestJSON(t *testing.T) {
+	s := newServer()
+	var logs bytes.Buffer
+	s.jsonLog = newJSONLogger(&logs, &s.logLevel)
+
+	// A request whose client has already gone away gets logged by logCutOff.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", "/items", nil)
+	s.router.ServeHTTP(httptest.NewRecorder(), req)
+
+	// The first line is logCutOff's; the access log comes after it.
+	var entry map[string]any
+	if err := json.NewDecoder(&logs).Decode(&entry); err != nil {
+		t.Fatalf("log output %q is not a JSON line: %v", logs.String(), err)
+	}
+	if entry["method"] != "GET" || entry["path"] != "/items" {
+		t.Errorf("wrong request fields: got method %v path %v", entry["method"], entry["path"])
+	}
+	if _, ok := entry["duration_ms"].(float64); !ok {
+		t.Errorf("expected a numeric duration_ms, got %v", entry["duration_ms"])
+	}
+	if _, ok := entry["status"]; ok {
+		t.Errorf("expected no status for a disconnected client, got %v", entry["status"])
+	}
+}
+
+// TestLogfText checks that the plain-text log is still the default.
+func TestLogfText(t *testing.T) {
+	s := newServer()
+	var logs bytes.Buffer
+	s.logger = log.New(&logs, "", 0)
+
+	s.logf("Bulk patched %d item(s)", 2)
+
+	if got := logs.String(); got != "Bulk patched 2 item(s)\n" {
+		t.Errorf("wrong text log line: got %q", got)
+	}
+}
+
+// TestJSONLogsFromEnv checks that only LOG_FORMAT=json switches to JSON lines.
+func TestJSONLogsFromEnv(t *testing.T) {
+	for value, want := range map[string]bool{"": false, "text": false, "json": true, "JSON": true} {
+		t.Setenv("LOG_FORMAT", value)
+		if got := jsonLogsFromEnv(); got != want {
+			t.Errorf("LOG_FORMAT=%q: got %v want %v", value, got, want)
+		}
+	}
+}
+
+// TestSetLogLevel checks that PUT /admin/log-level changes which messages get
+// through, straight away.
+func TestSetLogLevel(t *testing.T) {
+	s := newServer()
+	var logs bytes.Buffer
+	s.logger = log.New(&logs, "", 0)
+
+	setLevel := func(level string) {
+		t.Helper()
+		req, _ := http.NewRequest("PUT", "/admin/log-level", strings.NewReader(`{"level":"`+level+`"}`))
+		rr := httptest.NewRecorder()
+		s.router.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		logs.Reset()
+	}
+
+	// Debug messages are hidden at the default info level...
+	s.debugf("hidden")
+	if strings.Contains(logs.String(), "hidden") {
+		t.Errorf("debug message logged at info level: %q", logs.String())
+	}
+
+	// ...and show up once the level is turned down to debug.
+	setLevel("debug")
+	s.debugf("shown")
+	if !strings.Contains(logs.String(), "shown") {
+		t.Errorf("debug message missing at debug level: %q", logs.String())
+	}
+
+	// Raising it to error hides info and warnings but not errors.
+	setLevel("error")
+	s.logf("Imported 1 item(s) from CSV")
+	s.logf("WARNING something odd")
+	s.logf("ERROR something broke")
+	if got := logs.String(); got != "ERROR something broke\n" {
+		t.Errorf("wrong messages logged at error level: got %q", got)
+	}
+}
+
+// TestSetLogLevelInvalid checks that unknown level names are rejected and the
+// level is left alone.
+func TestSetLogLevelInvalid(t *testing.T) {
+	s := newServer()
+
+	req, _ := http.NewRequest("PUT", "/admin/log-level", strings.NewReader(`{"level":"verbose"}`))
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+	if level := s.logLevel.Level(); level != slog.LevelInfo {
+		t.Errorf("log level changed to %v by an invalid request", level)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// fallbackWriter is the log output. It writes to primary until a write fails
+// (say, because the disk holding the log file is full), then warns once and
+// switches to fallback for good, so we don't lose every log line from then on.
+//
+// It's only used through a log.Logger or a slog JSON handler, and only one of
+// them at a time. Both serialize their calls to Write, so it needs no locking
+// of its own.
+type fallbackWriter struct {
+	primary  io.Writer
+	fallback io.Writer
+	degraded bool
+}
+
+func (fw *fallbackWriter) Write(p []byte) (int, error) {
+	if !fw.degraded {
+		n, err := fw.primary.Write(p)
+		if err == nil {
+			return n, nil
+		}
+		fw.degraded = true
+		fmt.Fprintf(fw.fallback, "WARNING log output failed (%v), logging to fallback output from now on\n", err)
+	}
+	return fw.fallback.Write(p)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+)
+
+// failingWriter is a log sink that has stopped working, like a file on a full disk.
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("no space left on device")
+}
+
+// TestFallbackWriter checks that once the primary output fails, log lines go to
+// the fallback instead, with a single warning about the switch.
+func TestFallbackWriter(t *testing.T) {
+	var fallback bytes.Buffer
+	logger := log.New(&fallbackWriter{primary: failingWriter{}, fallback: &fallback}, "", 0)
+
+	logger.Println("first")
+	logger.Println("second")
+
+	got := fallback.String()
+	if !strings.Contains(got, "first\n") || !strings.Contains(got, "second\n") {
+		t.Errorf("log lines were lost:\n%s", got)
+	}
+	if n := strings.Count(got, "WARNING"); n != 1 {
+		t.Errorf("got %d warnings, want exactly 1:\n%s", n, got)
+	}
+}
@@ -3,17 +3,29 @@ package main
 
 // The import block lists all the external packages our code needs to function.
 import (
+	"bytes" // Used to read a byte slice as a stream.
 	"context"
 	"encoding/json" // Used for encoding and decoding JSON data.
+	"errors"        // Used for creating and comparing error values.
+	"flag"          // Used for parsing command-line flags.
 	"fmt"           // Used for formatted I/O, like printing strings with variables.
+	"io"            // Used for reading request bodies.
+	"io/fs"         // Used to recognise a missing data file.
 	"log"           // Provides logging capabilities.
+	"log/slog"      // Used for JSON log lines.
 	"net/http"      // The core package for all HTTP functionality.
 	"os"            // Used here to specify the output for our logger (standard output).
 	"os/signal"     // Used here to check for interrupt
+	"runtime"       // Used to size worker pools to the number of CPUs.
 	"strconv"       // Provides functions to convert strings to other types, like integers.
+	"strings"       // Used for splitting comma-separated query values.
+	"sync"          // Provides the mutex guarding the datastore.
+	"sync/atomic"   // Used for flags shared between goroutines.
+	"syscall"       // Used for the SIGTERM and SIGHUP signals.
 	"time"          // Used for adding timeout over here.
 
-	"github.com/go-chi/chi/v5" // The chi router we are using.
+	"github.com/go-chi/chi/v5"       // The chi router we are using.
+	"golang.org/x/sync/singleflight" // Lets concurrent identical lookups share one result.
 )
 
 // Item represents the data structure for the items we will store.
@@ -23,53 +35,418 @@ type Item struct {
 	ID   int    `json:"id"`
 	Name string `json:"name"`
 	Age  int    `json:"age"`
+
+	// Position orders items in a hand-arranged list, like a todo list. It's
+	// set by the client or by POST /items/reorder, and listings sort on it
+	// with ?sort=position.
+	Position int `json:"position"`
+
+	// The timestamps are managed by the server; whatever a client sends for
+	// them is overwritten.
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// ModifiedBy is who last created or changed the item, as worked out by
+	// requireAuth, or empty if the route didn't ask for credentials. Like the
+	// timestamps, it's the server's to set.
+	ModifiedBy string `json:"modified_by"`
+}
+
+// content returns the item without its server-managed fields, so two items
+// can be compared on just the fields a client controls.
+func (i Item) content() Item {
+	i.CreatedAt, i.UpdatedAt = time.Time{}, time.Time{}
+	i.ModifiedBy = ""
+	return i
+}
+
+// Validate checks that the item's fields hold sensible values. It's called on
+// every item before it's stored, so the datastore only ever holds valid items.
+// Keep itemSchema in step with the rules here.
+func (i Item) Validate() error {
+	if errs := i.validationErrors(); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// validationErrors returns everything wrong with the item, one error per
+// problem, or nil when it's valid. Validate only reports the first of them.
+func (i Item) validationErrors() []error {
+	var errs []error
+	if i.Name == "" {
+		errs = append(errs, errors.New("name must not be empty"))
+	}
+	if i.Age < 0 {
+		errs = append(errs, errors.New("age must not be negative"))
+	}
+	return errs
 }
 
 // server is a struct that holds all the dependencies for our application.
 // This is a form of dependency injection, making our app more modular and testable.
 type server struct {
-	logger    *log.Logger
-	router    chi.Router
-	datastore map[int]Item // Our simple in-memory database. The key is the item ID.
+	logger  *log.Logger  // Plain-text log output, used unless jsonLog is set.
+	jsonLog *slog.Logger // JSON log output, set when LOG_FORMAT=json.
+	// logLevel is the least severe level that gets logged, info until changed
+	// through PUT /admin/log-level.
+	logLevel slog.LevelVar
+	router   chi.Router
+
+	// Handlers run on separate goroutines. The store is safe to use from all
+	// of them, but a handler making several calls that have to act as one
+	// (check then write, say) holds mu around them: RLock to read, Lock to
+	// write. mu also guards the maps below and lastID. cacheMu additionally
+	// guards itemJSON, because the cache is filled in by readers holding only RLock.
+	mu            sync.RWMutex
+	cacheMu       sync.Mutex
+	store         Datastore            // Where the items are kept.
+	storeKind     string               // Which Datastore store is: "memory" or "sqlite".
+	itemJSON      map[int][]byte       // Cached JSON encoding of each item, filled in on first read.
+	avatars       map[int][]byte       // Uploaded avatar images, keyed by item ID.
+	byKey         map[compositeKey]int // Item IDs by composite key; nil unless composite-key mode is on.
+	lastID        int                  // The last ID handed out by nextID.
+	maxJSONDepth  int                  // How deeply objects and arrays may nest in a request body.
+	maxBodyBytes  int64                // The largest item body create and update will read.
+	importWorkers int                  // How many goroutines validate the rows of a bulk import.
+
+	// The memory guard refuses writes while heap usage is at or above heapLimit
+	// bytes (0 disables it). heapStats is where usage figures come from.
+	heapLimit  uint64
+	heapStats  func() uint64
+	memoryHigh atomic.Bool
+
+	// memoryBudget is how much memory GET /admin/sizing plans for, in bytes.
+	memoryBudget uint64
+
+	// dataFile is where the datastore is loaded from at startup and saved to
+	// on shutdown. With FLUSH_INTERVAL set, flushLoop also saves it through
+	// flush every so often while dirty says there are unsaved changes. Tests
+	// swap flush to count the writes.
+	dataFile string
+	flush    func() error
+	dirty    atomic.Bool
+
+	// syncWrites makes a create save the data file before answering, and
+	// take the item back out if that fails. writeFile is how the file gets
+	// written; tests swap it for one that fails.
+	syncWrites bool
+	writeFile  func(path string, data []byte) error
+	// saveMu is held by every save from encoding the items until the file is
+	// written, so saves land on disk in the order they read the datastore and
+	// an older copy can never replace a newer one. It's always taken after s.mu.
+	saveMu sync.Mutex
+
+	// now tells the time for item timestamps. Tests swap it for a fixed clock.
+	now func() time.Time
+
+	// defaultSort is how GET /items orders the items when the client doesn't
+	// say, set with -default-sort. The zero value lists them by ID.
+	defaultSort itemSort
+
+	// strictPutID makes PUT reject a body whose ID disagrees with the URL,
+	// instead of quietly replacing it with the URL's ID.
+	strictPutID bool
+
+	// weakETags makes GET /items/{id} hand out weak ETags instead of strong
+	// ones, set with -weak-etags.
+	weakETags bool
+
+	// authPolicy says what credentials each route requires, and apiKey,
+	// jwtSecret and adminToken are what they're checked against. A secret
+	// left empty turns off the checks that use it.
+	authPolicy map[string]authLevel
+	apiKey     string
+	jwtSecret  []byte
+	adminToken string
+
+	// trustProxy makes URLs pointing back at the server include the prefix
+	// from X-Forwarded-Prefix, and the rate limit go by X-Forwarded-For.
+	trustProxy bool
+
+	// inFlight counts the requests being served, and draining is set once
+	// shutdown has begun. Both are reported by GET /admin/draining.
+	inFlight atomic.Int64
+	draining atomic.Bool
+
+	// paused is set by POST /admin/pause, and makes non-admin requests get a 503.
+	paused atomic.Bool
+
+	// retryJitter is the most added at random to the Retry-After of a 503 or 429,
+	// so turned-away clients don't all retry at the same moment.
+	retryJitter time.Duration
+
+	// limiter caps how many requests are served at once, queueing the rest.
+	// It's nil, meaning no cap, unless MAX_CONCURRENT_REQUESTS is set.
+	limiter *requestLimiter
+	// rateLimiter caps how fast each client IP may send requests; nil means
+	// there's no cap.
+	rateLimiter *rateLimiter
+
+	// instanceID identifies this process, and replicas is how many copies of
+	// the server the deployment says are running.
+	instanceID string
+	replicas   int
+
+	// metrics counts requests and item changes between flushes.
+	metrics *counters
+	// prom holds the metrics GET /metrics serves to Prometheus.
+	prom *promMetrics
+
+	// snapshots holds labelled copies of the datastore, oldest first.
+	snapshotsMu sync.Mutex
+	snapshots   []snapshot
+
+	// GET /items/{id} looks items up through lookupItemJSON. While
+	// coalesceReads is on, concurrent lookups of the same ID share one call
+	// through reads. Tests swap lookupItemJSON to count the calls.
+	coalesceReads  bool
+	reads          singleflight.Group
+	lookupItemJSON func(id int) ([]byte, bool)
 }
 
+// defaultMaxJSONDepth is generous for our flat Item type while still stopping
+// bodies that nest thousands of levels deep.
+const defaultMaxJSONDepth = 32
+
 // newServer is the constructor function for our server. It's responsible for
 // creating and initializing all the components of our application.
 func newServer() *server {
 	// Create a new logger that writes to the standard output, with a prefix and standard flags.
-	logger := log.New(os.Stdout, "API: ", log.LstdFlags)
+	// Should writing to standard output ever fail, logging carries on to standard error.
+	logOutput := &fallbackWriter{primary: os.Stdout, fallback: os.Stderr}
+	logger := log.New(logOutput, "API: ", log.LstdFlags)
 	// Create a new chi router instance.
 	router := chi.NewRouter()
 
 	// Create an instance of our server struct.
 	s := &server{
-		logger:    logger,
-		router:    router,
-		datastore: make(map[int]Item), // Initialize the map! Otherwise, it's nil and will cause a crash.
+		logger:        logger,
+		router:        router,
+		itemJSON:      make(map[int][]byte),
+		avatars:       make(map[int][]byte),
+		maxJSONDepth:  defaultMaxJSONDepth,
+		maxBodyBytes:  maxBodyBytesFromEnv(),
+		importWorkers: runtime.NumCPU(),
+		heapStats:     heapInUse,
+		memoryBudget:  memoryBudgetFromEnv(),
+		now:           utcNow,
+		dataFile:      dataFileFromEnv(),
+		instanceID:    newInstanceID(),
+		replicas:      1,
+		coalesceReads: coalesceReadsFromEnv(),
+		syncWrites:    syncWritesFromEnv(),
+		writeFile:     writeFileAtomic,
+		retryJitter:   retryJitterFromEnv(),
+		apiKey:        os.Getenv("API_KEY"),
+		jwtSecret:     []byte(os.Getenv("JWT_SECRET")),
+		adminToken:    os.Getenv("ADMIN_TOKEN"),
+	}
+	s.lookupItemJSON = s.cachedItemJSON
+	s.metrics = newCounters(s.now())
+	s.prom = s.newPromMetrics()
+	s.flush = func() error { return s.saveToFile(s.dataFile) }
+	if jsonLogsFromEnv() {
+		s.jsonLog = newJSONLogger(logOutput, &s.logLevel)
+	}
+
+	// Pick the storage backend before anything reads or writes items.
+	store, kind, err := storeFromEnv()
+	if err != nil {
+		s.fatalf("Cannot open datastore: %v", err)
+	}
+	s.store, s.storeKind = store, kind
+	// Database errors the Datastore interface can't return go to our log.
+	if st, ok := store.(*sqliteStore); ok {
+		st.logf = s.logf
+	}
+	// A SQLite database keeps the items itself, so there's no data file to
+	// load or save.
+	if kind == "sqlite" {
+		s.dataFile = ""
+	}
+
+	// The policy has to be known before the routes are registered.
+	if s.authPolicy, err = authPolicyFromEnv(); err != nil {
+		s.fatalf("Cannot read auth policy: %v", err)
 	}
 
-	// Set up the application's routes.
-	s.routes()
+	// Set up the application's routes. A failure here means a route was
+	// declared with a malformed pattern, so there is no point in starting up.
+	// Fatalf logs the error and exits with status code 1.
+	if err := s.routes(); err != nil {
+		s.fatalf("Cannot register routes: %v", err)
+	}
+
+	// Pick up where the last run left off. A missing file just means there's
+	// nothing to load yet, but any other failure stops us from starting up:
+	// carrying on with an empty store would overwrite the file on shutdown.
+	if s.dataFile != "" {
+		if err := s.loadFromFile(s.dataFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			s.fatalf("Cannot load data from %s: %v", s.dataFile, err)
+		}
+		// What was just loaded is already on disk, and reloading it isn't
+		// activity the metrics should count.
+		s.dirty.Store(false)
+		s.metrics.flush(s.now())
+	}
 	return s
 }
 
+// utcNow is the server's default clock. Converting to UTC also drops Go's
+// monotonic clock reading, so timestamps compare equal after a JSON round trip.
+func utcNow() time.Time {
+	return time.Now().UTC()
+}
+
+// route describes a single API endpoint: the HTTP method, the URL pattern and
+// the handler that serves it.
+type route struct {
+	method  string
+	pattern string
+	handler http.HandlerFunc
+}
+
 // routes defines all the application's API endpoints and maps them to their handlers.
-func (s *server) routes() {
-	// A POST request to /items will create a new item.
-	s.router.Post("/items", s.handleCreateItem())
-	// A GET request to /items/{id} will retrieve a specific item.
-	s.router.Get("/items/{id}", s.handleGetItem())
-	// A PUT request to /items/{id} will update a specific item.
-	s.router.Put("/items/{id}", s.handleChangeItem())
-	// A GET request to /slow for gracefull shutdown
-	s.router.Get("/slow", s.handleSlow())
+func (s *server) routes() error {
+	// Middleware must be registered before any routes, so it wraps all of them.
+	s.router.Use(s.assignRequestID)
+	s.router.Use(s.trackInFlight)
+	s.router.Use(s.closeWhenDraining)
+	s.router.Use(s.loggingMiddleware)
+	s.router.Use(s.instrument)
+	s.router.Use(s.recoverMiddleware)
+	s.router.Use(s.logCutOff)
+	s.router.Use(s.requireSupportedProto)
+	s.router.Use(s.rejectTrace)
+	s.router.Use(s.rejectSuspiciousPaths)
+	s.router.Use(http10Compat)
+	s.router.Use(s.pauseGate)
+	s.router.Use(s.guardMemory)
+	s.router.Use(s.rateLimit)
+	s.router.Use(s.limitRequests)
+
+	return s.mount([]route{
+		// A POST request to /items will create a new item.
+		{http.MethodPost, "/items", s.handleCreateItem()},
+		// A GET request to /items will list every item.
+		{http.MethodGet, "/items", s.handleListItems()},
+		// A GET request to /items/stream streams the items as NDJSON.
+		{http.MethodGet, "/items/stream", s.handleStreamItems()},
+		// A GET request to /items/by-key finds an item by name and age.
+		{http.MethodGet, "/items/by-key", s.handleGetItemByKey()},
+		// A GET request to /items/{id} will retrieve a specific item.
+		{http.MethodGet, "/items/{id}", s.handleGetItem()},
+		// A PUT request to /items/{id} will update a specific item.
+		{http.MethodPut, "/items/{id}", s.handleChangeItem()},
+		// A GET request to /items/{id}/{field} returns a single field of an item.
+		{http.MethodGet, "/items/{id}/{field}", s.handleGetItemField()},
+		// A DELETE request to /items/{id} will remove a specific item.
+		{http.MethodDelete, "/items/{id}", s.handleDeleteItem()},
+		// A POST request to /items/{id}/cas replaces an item only if it is unchanged.
+		{http.MethodPost, "/items/{id}/cas", s.handleCompareAndSetItem()},
+		// A PATCH request to /items applies one partial update to several items.
+		{http.MethodPatch, "/items", s.handleBulkPatchItems()},
+		// Give items new positions, in the order their IDs are listed.
+		{http.MethodPost, "/items/reorder", s.handleReorderItems()},
+		// A POST request to /items/import/csv creates many items from a CSV file.
+		{http.MethodPost, "/items/import/csv", s.handleImportCSV()},
+		// A POST request to /items/validate-batch checks items without storing them.
+		{http.MethodPost, "/items/validate-batch", s.handleValidateBatch()},
+		// A GET request to /items.html shows the items as an HTML table.
+		{http.MethodGet, "/items.html", s.handleItemsHTML()},
+		// PUT and GET requests to /items/{id}/avatar upload and fetch an item's image.
+		{http.MethodPut, "/items/{id}/avatar", s.handlePutAvatar()},
+		{http.MethodGet, "/items/{id}/avatar", s.handleGetAvatar()},
+		// A GET request to /schema describes the fields of an item.
+		{http.MethodGet, "/schema", s.handleSchema()},
+		// A GET request to /admin/draining reports the shutdown drain progress.
+		{http.MethodGet, "/admin/draining", s.handleDraining()},
+		// POST requests to /admin/pause and /admin/resume stop and restart
+		// processing of all non-admin requests.
+		{http.MethodPost, "/admin/pause", s.handleSetPaused(true)},
+		{http.MethodPost, "/admin/resume", s.handleSetPaused(false)},
+		// A PUT request to /admin/log-level changes how much gets logged.
+		{http.MethodPut, "/admin/log-level", s.handleSetLogLevel()},
+		// A GET request to /admin/replica-info identifies this instance.
+		{http.MethodGet, "/admin/replica-info", s.handleReplicaInfo()},
+		// A POST request to /admin/snapshot saves a copy of the datastore, and a
+		// GET request to /admin/diff compares two such copies.
+		{http.MethodPost, "/admin/snapshot", s.handleTakeSnapshot()},
+		{http.MethodGet, "/admin/diff", s.handleDiffSnapshots()},
+		// A POST request to /admin/gc forces a garbage collection.
+		{http.MethodPost, "/admin/gc", s.handleForceGC()},
+		// A GET request to /admin/buildinfo reports how the binary was built.
+		{http.MethodGet, "/admin/buildinfo", s.handleBuildInfo()},
+		// A GET request to /admin/sizing estimates memory use per item.
+		{http.MethodGet, "/admin/sizing", s.handleSizing()},
+		// A POST request to /admin/metrics/flush collects and resets the counters.
+		{http.MethodPost, "/admin/metrics/flush", s.handleFlushMetrics()},
+		// A GET request to /metrics serves the metrics for Prometheus to scrape.
+		{http.MethodGet, "/metrics", s.handlePrometheus()},
+		// A GET request to /healthz reports that the server is up.
+		{http.MethodGet, "/healthz", s.handleHealthz()},
+		// A GET request to /slow for gracefull shutdown
+		{http.MethodGet, "/slow", s.handleSlow()},
+	})
+}
+
+// mount registers every route on the router, stopping at the first one that fails.
+func (s *server) mount(routes []route) error {
+	for _, rt := range routes {
+		if err := s.register(rt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// register adds a single route to the router, behind whatever credentials
+// s.authPolicy requires of it. chi panics with a rather cryptic
+// message when it's handed a malformed pattern, so we recover from that panic
+// and turn it into an error that names the offending route.
+func (s *server) register(rt route) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("invalid route %s %q: %v", rt.method, rt.pattern, r)
+		}
+	}()
+	level := authLevelFor(s.authPolicy, rt.method, rt.pattern)
+	s.router.Method(rt.method, rt.pattern, s.requireAuth(level, rt.handler))
+	return nil
+}
+
+// handleHealthz handles liveness checks (GET /healthz). Getting an answer at
+// all is the point, so it always reports ok, and the pause, the rate limit and
+// the request queue all let it through.
+func (s *server) handleHealthz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	}
 }
 
+// statusClientClosedRequest is the non-standard 499 status nginx made popular
+// for requests the client gave up on before they were answered.
+const statusClientClosedRequest = 499
+
+// slowDuration is how long GET /slow takes to answer.
+const slowDuration = 10 * time.Second
+
+// handleSlow simulates a long-running task, for trying out graceful shutdown
+// (GET /slow). It stops early if the client goes away.
 func (s *server) handleSlow() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		s.logger.Println("Starting slow request...")
-		time.Sleep(10 * time.Second) // Simulate a long-running task
-		s.logger.Println("Finished slow request.")
+		s.reqLogf(r, "Starting slow request...")
+		select {
+		case <-time.After(slowDuration): // Simulate a long-running task
+		case <-r.Context().Done():
+			// Nobody will read the 499, but it shows up in the access log.
+			s.reqLogf(r, "Slow request canceled: %v", r.Context().Err())
+			w.WriteHeader(statusClientClosedRequest)
+			return
+		}
+		s.reqLogf(r, "Finished slow request.")
 		fmt.Fprintf(w, "Finally, I am done.")
 	}
 }
@@ -81,31 +458,95 @@ func (s *server) handleCreateItem() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Create a variable to store the JSON data from the request body.
 		var newItem Item
-		// Create a new JSON decoder that reads from the request body.
-		err := json.NewDecoder(r.Body).Decode(&newItem)
+		// Decode the JSON from the request body into our variable, reading no
+		// more of it than the size limit allows.
+		s.limitBody(w, r)
+		err := s.decodeItemJSON(r, &newItem)
 		if err != nil {
-			// If decoding fails, log the error and send a 400 Bad Request to the client.
-			s.logger.Printf("ERROR decoding request body: %v", err)
-			http.Error(w, "Bad request: invalid JSON", http.StatusBadRequest)
+			// If decoding fails, log the error and tell the client what was wrong.
+			s.reqLogf(r, "ERROR decoding request body: %v", err)
+			writeDecodeError(w, err)
+			return
+		}
+		// Make sure the item is one we're willing to store.
+		if err := newItem.Validate(); err != nil {
+			s.reqLogf(r, "Rejected invalid item: %v", err)
+			writeValidationError(w, err)
 			return
 		}
 
+		// With ?require_absent_name=X, the create only goes ahead while no
+		// item is named X, which guards against logical duplicates that IDs
+		// alone don't catch.
+		absentName := r.URL.Query().Get("require_absent_name")
+		requireAbsent := r.URL.Query().Has("require_absent_name")
+
+		// The duplicate checks and the insert happen under one lock, so two
+		// requests can't both claim the same ID, or the same name.
+		s.mu.Lock()
+		if requireAbsent {
+			if existing, found := s.itemNamed(absentName); found {
+				s.mu.Unlock()
+				s.reqLogf(r, "Refused to create item: name %q already taken by item %d", absentName, existing.ID)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusConflict)
+				json.NewEncoder(w).Encode(createConflict{
+					Error:    fmt.Sprintf("an item named %q already exists", absentName),
+					Existing: existing,
+				})
+				return
+			}
+		}
+		// An ID of 0 means the client left it out, so we pick one ourselves.
+		if newItem.ID == 0 {
+			newItem.ID = s.nextID()
+		}
 		// Check if an item with this ID already exists in our datastore.
-		_, found := s.datastore[newItem.ID]
+		existing, found := s.store.Get(newItem.ID)
 		if found {
-			s.logger.Printf("Attempted to create item with duplicate ID: %d", newItem.ID)
+			s.mu.Unlock()
+			s.reqLogf(r, "Attempted to create item with duplicate ID: %d", newItem.ID)
 			// Respond with a 409 Conflict error, which is more specific than 400.
-			http.Error(w, fmt.Sprintf("Error: ID %d already in use", newItem.ID), http.StatusConflict)
+			// Including the item that's in the way lets the client decide
+			// whether to update it instead.
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(createConflict{
+				Error:    fmt.Sprintf("ID %d already in use", newItem.ID),
+				Existing: existing,
+			})
 			return
 		}
 
-		// If everything is okay, store the new item in our datastore map.
-		s.datastore[newItem.ID] = newItem
-		s.logger.Printf("Successfully created and stored item: %+v", newItem)
+		// If everything is okay, stamp the item and store it in our datastore.
+		newItem.CreatedAt = s.now()
+		newItem.UpdatedAt = newItem.CreatedAt
+		newItem.ModifiedBy = principalFrom(r.Context())
+		wasDirty := s.dirty.Load()
+		if err := s.putItem(newItem); err != nil {
+			s.mu.Unlock()
+			s.writeStoreError(w, r, err)
+			return
+		}
+		// With SYNC_WRITES on, the create only succeeds once it's on disk. If
+		// saving fails (say, the disk is full), the item is taken back out, so
+		// the client is never told about an item a restart would lose.
+		if s.syncWrites && s.dataFile != "" {
+			if err := s.saveLocked(); err != nil {
+				s.discardItem(newItem, wasDirty)
+				s.mu.Unlock()
+				s.writeSaveError(w, r, err)
+				return
+			}
+		}
+		s.mu.Unlock()
+		s.reqLogf(r, "Successfully created and stored item: %+v", newItem)
 
 		// --- Respond to the client ---
 		// Set the Content-Type header to inform the client we are sending JSON.
 		w.Header().Set("Content-Type", "application/json")
+		// The Location header tells the client where the new item lives.
+		w.Header().Set("Location", s.externalPath(r, fmt.Sprintf("/items/%d", newItem.ID)))
 		// Set the HTTP status code to 201 Created.
 		w.WriteHeader(http.StatusCreated)
 		// Encode the newly created item into JSON and write it to the response.
@@ -113,6 +554,96 @@ func (s *server) handleCreateItem() http.HandlerFunc {
 	}
 }
 
+// createConflict is the body of the 409 returned when creating an item whose
+// ID is taken, or whose require_absent_name is.
+type createConflict struct {
+	Error    string `json:"error"`
+	Existing Item   `json:"existing"`
+}
+
+// handleListItems handles requests to list the stored items, optionally
+// filtered (e.g., GET /items?min_age=18 or GET /items?name=ali). X-Total-Count says how
+// many items there are in all, and X-Filtered-Count how many passed the filters.
+//
+// With ids (e.g., GET /items?ids=1,2,3) only those items are listed, in the
+// order given, and any that don't exist are named in X-Missing-IDs.
+//
+// sort orders the matching items by id, name, age, position or created_at,
+// descending with a "-" in front (e.g., GET /items?sort=-age). Without it
+// they're listed in the -default-sort order, by ID unless that says otherwise.
+//
+// The matching items are returned a page at a time (limit and offset, like
+// GET /items?limit=20&offset=40), wrapped in an itemList.
+func (s *server) handleListItems() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseItemFilter(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Bad request: %v", err))
+			return
+		}
+		limit, offset, err := parsePaging(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Bad request: %v", err))
+			return
+		}
+		var ids []int
+		if v := r.URL.Query().Get("ids"); v != "" {
+			if ids, err = parseIDList(v); err != nil {
+				writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Bad request: %v", err))
+				return
+			}
+		}
+		// Listed IDs come back in the order they were given unless a sort is
+		// asked for; otherwise the deployment's default order applies.
+		defaultSort := s.defaultSort
+		if ids != nil {
+			defaultSort = itemSort{}
+		}
+		order, err := parseItemSort(r, defaultSort)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Bad request: %v", err))
+			return
+		}
+
+		// sortedItems never returns nil, and neither does apply, so an empty
+		// page is encoded as [] rather than null.
+		s.mu.RLock()
+		all := s.sortedItems()
+		s.mu.RUnlock()
+		items := all
+		if ids != nil {
+			var missing []int
+			items, missing = pickIDs(all, ids)
+			if len(missing) > 0 {
+				w.Header().Set("X-Missing-IDs", joinInts(missing))
+			}
+		}
+		// apply returns a fresh slice, so sorting it leaves the store alone.
+		items = filter.apply(items)
+		order.apply(items)
+
+		w.Header().Set("X-Total-Count", strconv.Itoa(len(all)))
+		w.Header().Set("X-Filtered-Count", strconv.Itoa(len(items)))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(itemList{
+			Items:  page(items, limit, offset),
+			Total:  len(items),
+			Limit:  limit,
+			Offset: offset,
+		})
+	}
+}
+
+// itemList is the body returned by GET /items: one page of the matching
+// items, and where it sits among them. Total counts every matching item, not
+// just the ones on this page.
+type itemList struct {
+	Items  []Item `json:"items"`
+	Total  int    `json:"total"`
+	Limit  int    `json:"limit"`
+	Offset int    `json:"offset"`
+}
+
 // handleGetItem handles requests to retrieve a single item by its ID (e.g., GET /items/101).
 func (s *server) handleGetItem() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -122,61 +653,295 @@ func (s *server) handleGetItem() http.HandlerFunc {
 		// The ID from the URL is a string, so we need to convert it to an integer.
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
-			s.logger.Printf("ERROR converting ID string to int: %v", err)
-			http.Error(w, "Invalid item ID", http.StatusBadRequest)
+			s.reqLogf(r, "ERROR converting ID string to int: %v", err)
+			writeJSONError(w, http.StatusBadRequest, "Invalid item ID")
 			return
 		}
 
-		// Look up the item in our datastore using the integer ID.
-		// The "value, found" is a common Go idiom for checking if a key exists in a map.
-		item, found := s.datastore[id]
+		// Derived fields are only computed when asked for (e.g., ?expand=age_group),
+		// so the plain response can keep being served from the JSON cache.
+		if expand := r.URL.Query().Get("expand"); expand != "" {
+			// Look up the item in our datastore using the integer ID.
+			// The "value, found" is a common Go idiom for checking if a key exists in a map.
+			item, found := s.store.Get(id)
+			if !found {
+				s.reqLogf(r, "Item with ID %d not found", id)
+				writeJSONError(w, http.StatusNotFound, "Item not found")
+				return
+			}
+			expanded, err := expandItem(item, strings.Split(expand, ","))
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Bad request: %v", err))
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(expanded)
+			return
+		}
+
+		// Identical concurrent GETs share a single lookup of the item.
+		body, found := s.readItemJSON(id)
 		if !found {
-			s.logger.Printf("Item with ID %d not found", id)
+			s.reqLogf(r, "Item with ID %d not found", id)
 			// If the item doesn't exist, respond with a 404 Not Found error.
-			http.Error(w, "Item not found", http.StatusNotFound)
+			writeJSONError(w, http.StatusNotFound, "Item not found")
 			return
 		}
 
-		// If the item is found, respond with it.
+		// A client whose copy is still current gets a 304 instead of the body.
+		etag := s.itemETag(body)
+		w.Header().Set("ETag", etag)
+		if etagMatchesNoneOf(r, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		// If the item is found, respond with its (possibly cached) JSON encoding.
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(item)
+		w.Write(body)
 	}
 }
 
-// handleChangeItem handles requests to update an existing item (e.g., PUT /items/101).
-func (s *server) handleChangeItem() http.HandlerFunc {
+// handleGetItemField handles requests for one field of an item, returned as a
+// bare JSON value (e.g., GET /items/101/name returns "Alice").
+func (s *server) handleGetItemField() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// --- First, find the existing item just like in handleGetItem ---
 		idStr := chi.URLParam(r, "id")
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
-			s.logger.Printf("ERROR converting ID to int: %v", err)
-			http.Error(w, "Invalid item ID", http.StatusBadRequest)
+			s.reqLogf(r, "ERROR converting ID string to int: %v", err)
+			writeJSONError(w, http.StatusBadRequest, "Invalid item ID")
 			return
 		}
 
-		// Check if the item we are trying to update actually exists.
-		_, found := s.datastore[id]
+		item, found := s.store.Get(id)
 		if !found {
-			s.logger.Printf("Attempted to update non-existent item with ID %d", id)
-			http.Error(w, "Item not found", http.StatusNotFound)
+			writeJSONError(w, http.StatusNotFound, "Item not found")
+			return
+		}
+
+		var value any
+		switch field := chi.URLParam(r, "field"); field {
+		case "name":
+			value = item.Name
+		case "age":
+			value = item.Age
+		default:
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Bad request: unknown field %q", field))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(value)
+	}
+}
+
+// expandedItem is an Item plus the derived fields a client asked for with
+// ?expand=. Fields that weren't requested are left empty and omitted.
+type expandedItem struct {
+	Item
+	AgeGroup string `json:"age_group,omitempty"`
+}
+
+// expandItem computes the requested derived fields for item. An unknown field
+// name is an error.
+func expandItem(item Item, fields []string) (expandedItem, error) {
+	expanded := expandedItem{Item: item}
+	for _, field := range fields {
+		switch strings.TrimSpace(field) {
+		case "age_group":
+			expanded.AgeGroup = ageGroup(item.Age)
+		default:
+			return expandedItem{}, fmt.Errorf("unknown expansion %q", field)
+		}
+	}
+	return expanded, nil
+}
+
+// ageGroup classifies an age as "minor" (under 18) or "adult".
+func ageGroup(age int) string {
+	if age < 18 {
+		return "minor"
+	}
+	return "adult"
+}
+
+// putItem stores item in the datastore, replacing any item with the same ID.
+// Every write goes through here so that the cached JSON for the item is
+// dropped and the next read re-encodes it, which guarantees a stale encoding
+// is never served. s.mu must be held for writing.
+//
+// In composite-key mode it also keeps the key index up to date, and refuses
+// with a keyTakenError to give item a key another item already has.
+func (s *server) putItem(item Item) error {
+	if err := s.checkKey(item); err != nil {
+		return err
+	}
+	old, found := s.store.Get(item.ID)
+	var err error
+	if found {
+		err = s.store.Update(item.ID, item)
+	} else {
+		err = s.store.Create(item)
+	}
+	s.cacheMu.Lock()
+	delete(s.itemJSON, item.ID)
+	s.cacheMu.Unlock()
+	if err != nil {
+		return err
+	}
+	s.markDirty()
+	if found {
+		s.unindexKey(old)
+		s.metrics.add("items_updated", 1)
+	} else {
+		s.metrics.add("items_created", 1)
+	}
+	s.indexKey(item)
+	return nil
+}
+
+// nextID returns the next unused item ID. Clients may pick their own IDs too,
+// so any ID that's already taken is skipped. s.mu must be held for writing.
+func (s *server) nextID() int {
+	for {
+		s.lastID++
+		if _, taken := s.store.Get(s.lastID); !taken {
+			return s.lastID
+		}
+	}
+}
+
+// itemNamed returns the first item, by ID, whose name is exactly name. It
+// scans every item, so s.mu must be held throughout whatever depends on the
+// answer, for writing if that's a create.
+func (s *server) itemNamed(name string) (Item, bool) {
+	for _, item := range s.sortedItems() {
+		if item.Name == name {
+			return item, true
+		}
+	}
+	return Item{}, false
+}
+
+// sortedItems returns every stored item, ordered by ID. It never returns nil.
+// s.mu must be held, for reading at least, so a multi-item write isn't seen halfway.
+func (s *server) sortedItems() []Item {
+	return s.store.List()
+}
+
+// removeItem deletes the item with the given ID, along with everything we keep
+// about it on the side: its cached JSON and its avatar. s.mu must be held for writing.
+func (s *server) removeItem(id int) error {
+	old, _ := s.store.Get(id)
+	if err := s.store.Delete(id); err != nil {
+		return err
+	}
+	s.markDirty()
+	s.metrics.add("items_deleted", 1)
+	s.unindexKey(old)
+	delete(s.avatars, id)
+	s.cacheMu.Lock()
+	delete(s.itemJSON, id)
+	s.cacheMu.Unlock()
+	return nil
+}
+
+// discardItem takes back an item putItem has just created, as if it had never
+// been stored. Unlike removeItem it counts no delete, takes the create back
+// out of the metrics, and puts the dirty flag back to wasDirty, what it was
+// before the create. s.mu must be held for writing.
+func (s *server) discardItem(item Item, wasDirty bool) {
+	if err := s.store.Delete(item.ID); err != nil {
+		s.logf("ERROR discarding item %d: %v", item.ID, err)
+		return
+	}
+	s.dirty.Store(wasDirty)
+	s.metrics.add("items_created", -1)
+	s.unindexKey(item)
+	s.cacheMu.Lock()
+	delete(s.itemJSON, item.ID)
+	s.cacheMu.Unlock()
+}
+
+// itemJSONBytes returns the JSON encoding of item, encoding it only the first
+// time and serving the cached bytes after that. s.mu must be held, for reading
+// at least, so the item can't change while its encoding is being cached.
+func (s *server) itemJSONBytes(item Item) []byte {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	if b, found := s.itemJSON[item.ID]; found {
+		return b
+	}
+	b, _ := json.Marshal(item) // An Item always marshals cleanly.
+	// Add the trailing newline json.Encoder would have written, so responses look the same.
+	b = append(b, '\n')
+	s.itemJSON[item.ID] = b
+	return b
+}
+
+// handleChangeItem handles requests to update an existing item (e.g., PUT /items/101).
+func (s *server) handleChangeItem() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// --- First, parse the ID just like in handleGetItem ---
+		idStr := chi.URLParam(r, "id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			s.reqLogf(r, "ERROR converting ID to int: %v", err)
+			writeJSONError(w, http.StatusBadRequest, "Invalid item ID")
 			return
 		}
 
 		// --- Now, decode the new data from the request body ---
 		var updatedItem Item
-		err = json.NewDecoder(r.Body).Decode(&updatedItem)
+		s.limitBody(w, r)
+		err = s.decodeItemJSON(r, &updatedItem)
 		if err != nil {
-			s.logger.Printf("ERROR decoding request body: %v", err)
-			http.Error(w, "Bad request: invalid JSON", http.StatusBadRequest)
+			s.reqLogf(r, "ERROR decoding request body: %v", err)
+			writeDecodeError(w, err)
+			return
+		}
+
+		if err := updatedItem.Validate(); err != nil {
+			s.reqLogf(r, "Rejected invalid update of item %d: %v", id, err)
+			writeValidationError(w, err)
+			return
+		}
+
+		// A body ID of 0 means "not given". Any other value must match the URL
+		// when running in strict mode.
+		if s.strictPutID && updatedItem.ID != 0 && updatedItem.ID != id {
+			s.reqLogf(r, "Rejected update of item %d with mismatched body ID %d", id, updatedItem.ID)
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Bad request: body ID %d does not match URL ID %d", updatedItem.ID, id))
 			return
 		}
 
 		// --- Update the item in our datastore ---
+		// The body has been read, so we no longer depend on the client's speed
+		// and can take the write lock for the check-then-replace.
+		s.mu.Lock()
+		// Check if the item we are trying to update actually exists.
+		existing, found := s.store.Get(id)
+		if !found {
+			s.mu.Unlock()
+			s.reqLogf(r, "Attempted to update non-existent item with ID %d", id)
+			writeJSONError(w, http.StatusNotFound, "Item not found")
+			return
+		}
 		// Enforce the ID from the URL to prevent a mismatch with the body.
 		updatedItem.ID = id
-		s.datastore[id] = updatedItem // Replace the old item with the new one at the same ID.
-		s.logger.Printf("Successfully updated item with ID: %d", id)
+		// The item keeps its creation time; only the update time moves on.
+		updatedItem.CreatedAt = existing.CreatedAt
+		updatedItem.UpdatedAt = s.now()
+		updatedItem.ModifiedBy = principalFrom(r.Context())
+		// Replace the old item with the new one at the same ID.
+		if err := s.putItem(updatedItem); err != nil {
+			s.mu.Unlock()
+			s.writeStoreError(w, r, err)
+			return
+		}
+		s.mu.Unlock()
+		s.reqLogf(r, "Successfully updated item with ID: %d", id)
 
 		// --- Respond with the updated item ---
 		w.Header().Set("Content-Type", "application/json")
@@ -184,51 +949,555 @@ func (s *server) handleChangeItem() http.HandlerFunc {
 	}
 }
 
+// handleDeleteItem handles requests to remove an item (e.g., DELETE /items/101).
+func (s *server) handleDeleteItem() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idStr := chi.URLParam(r, "id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			s.reqLogf(r, "ERROR converting ID to int: %v", err)
+			writeJSONError(w, http.StatusBadRequest, "Invalid item ID")
+			return
+		}
+
+		// We can only delete an item that exists.
+		s.mu.Lock()
+		_, found := s.store.Get(id)
+		if !found {
+			s.mu.Unlock()
+			s.reqLogf(r, "Attempted to delete non-existent item with ID %d", id)
+			writeJSONError(w, http.StatusNotFound, "Item not found")
+			return
+		}
+
+		if err := s.removeItem(id); err != nil {
+			s.mu.Unlock()
+			s.writeStoreError(w, r, err)
+			return
+		}
+		s.mu.Unlock()
+		s.reqLogf(r, "Successfully deleted item with ID: %d", id)
+
+		// 204 No Content tells the client it worked and that there's no body to read.
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// casRequest is the body accepted by POST /items/{id}/cas.
+type casRequest struct {
+	Expected Item `json:"expected"`
+	New      Item `json:"new"`
+}
+
+// handleCompareAndSetItem handles requests to replace an item only if its current
+// value is exactly what the client expects (e.g., POST /items/101/cas). On a
+// mismatch the current value is returned with a 409, so the client can retry
+// from there.
+func (s *server) handleCompareAndSetItem() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idStr := chi.URLParam(r, "id")
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			s.reqLogf(r, "ERROR converting ID to int: %v", err)
+			writeJSONError(w, http.StatusBadRequest, "Invalid item ID")
+			return
+		}
+
+		var req casRequest
+		err = s.decodeJSON(r, &req)
+		if err != nil {
+			s.reqLogf(r, "ERROR decoding request body: %v", err)
+			writeJSONError(w, http.StatusBadRequest, decodeErrorMessage(err))
+			return
+		}
+		// The URL decides which item this is, just like in handleChangeItem.
+		req.Expected.ID, req.New.ID = id, id
+		if err := req.New.Validate(); err != nil {
+			s.reqLogf(r, "Rejected invalid compare-and-set of item %d: %v", id, err)
+			writeValidationError(w, err)
+			return
+		}
+
+		// The comparison and the swap happen under one write lock, so nobody
+		// can change the item in between.
+		s.mu.Lock()
+		current, found := s.store.Get(id)
+		if !found {
+			s.mu.Unlock()
+			writeJSONError(w, http.StatusNotFound, "Item not found")
+			return
+		}
+		// Clients can't be expected to echo the timestamps back exactly, so
+		// only the fields they control are compared.
+		swapped := current.content() == req.Expected.content()
+		if swapped {
+			req.New.CreatedAt = current.CreatedAt
+			req.New.UpdatedAt = s.now()
+			req.New.ModifiedBy = principalFrom(r.Context())
+			if err := s.putItem(req.New); err != nil {
+				s.mu.Unlock()
+				s.writeStoreError(w, r, err)
+				return
+			}
+		}
+		s.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if !swapped {
+			s.reqLogf(r, "Compare-and-set of item %d failed: item has changed", id)
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(current)
+			return
+		}
+		s.reqLogf(r, "Compare-and-set of item %d succeeded", id)
+		json.NewEncoder(w).Encode(req.New)
+	}
+}
+
+// itemPatch is a partial update to an Item. Fields left out of the JSON stay
+// nil, which means "keep the current value".
+type itemPatch struct {
+	Name *string `json:"name"`
+	Age  *int    `json:"age"`
+}
+
+// apply returns a copy of item with the patch's non-nil fields applied.
+func (p itemPatch) apply(item Item) Item {
+	if p.Name != nil {
+		item.Name = *p.Name
+	}
+	if p.Age != nil {
+		item.Age = *p.Age
+	}
+	return item
+}
+
+// bulkPatchRequest is the body accepted by PATCH /items.
+type bulkPatchRequest struct {
+	IDs   []int     `json:"ids"`
+	Patch itemPatch `json:"patch"`
+}
+
+// bulkPatchResult reports what happened to a single ID in a bulk patch.
+type bulkPatchResult struct {
+	ID     int    `json:"id"`
+	Status string `json:"status"`          // "updated", "not_found", "invalid", "conflict" or "error".
+	Item   *Item  `json:"item,omitempty"`  // The merged item, when it was updated.
+	Error  string `json:"error,omitempty"` // Why the merged item couldn't be stored.
+}
+
+// handleBulkPatchItems handles requests to apply the same partial update to many
+// items at once (e.g., PATCH /items with {"ids":[1,2],"patch":{"age":30}}).
+func (s *server) handleBulkPatchItems() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req bulkPatchRequest
+		err := s.decodeJSON(r, &req)
+		if err != nil {
+			s.reqLogf(r, "ERROR decoding request body: %v", err)
+			writeJSONError(w, http.StatusBadRequest, decodeErrorMessage(err))
+			return
+		}
+		if len(req.IDs) == 0 {
+			writeJSONError(w, http.StatusBadRequest, "Bad request: no ids given")
+			return
+		}
+
+		// Walk the IDs in the order they were given. A missing ID is reported
+		// in its result rather than failing the whole request.
+		// All the items are patched under one write lock, so readers see
+		// either none or all of the changes.
+		results := make([]bulkPatchResult, 0, len(req.IDs))
+		s.mu.Lock()
+		for _, id := range req.IDs {
+			item, found := s.store.Get(id)
+			if !found {
+				results = append(results, bulkPatchResult{ID: id, Status: "not_found"})
+				continue
+			}
+			item = req.Patch.apply(item)
+			item.UpdatedAt = s.now()
+			item.ModifiedBy = principalFrom(r.Context())
+			// A patch that would leave this item invalid is skipped, but
+			// doesn't stop the others from being applied.
+			if err := item.Validate(); err != nil {
+				results = append(results, bulkPatchResult{ID: id, Status: "invalid", Error: err.Error()})
+				continue
+			}
+			if err := s.putItem(item); isKeyTaken(err) {
+				results = append(results, bulkPatchResult{ID: id, Status: "conflict", Error: err.Error()})
+				continue
+			} else if err != nil {
+				s.reqLogf(r, "ERROR patching item %d: %v", id, err)
+				results = append(results, bulkPatchResult{ID: id, Status: "error", Error: "could not store the item"})
+				continue
+			}
+			results = append(results, bulkPatchResult{ID: id, Status: "updated", Item: &item})
+		}
+		s.mu.Unlock()
+		s.reqLogf(r, "Bulk patched %d item(s)", len(req.IDs))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(results)
+	}
+}
+
+// writeJSONError answers with the given status and a JSON body like
+// {"error":"Item not found"}. Every error response goes through here, so API
+// clients can always decode the body as JSON, failure or not.
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	// Like http.Error, make sure the body isn't sniffed as something else.
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+// writeValidationError tells the client their item was rejected by Validate,
+// with a 422 Unprocessable Entity.
+func writeValidationError(w http.ResponseWriter, err error) {
+	writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+}
+
+// writeStoreError answers when putItem or removeItem fails: with a 409
+// Conflict for a composite key that's already taken, and otherwise with a 500
+// Internal Server Error, since the datastore failed at something that should
+// have worked. The details of those only go to the log.
+func (s *server) writeStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	if isKeyTaken(err) {
+		writeJSONError(w, http.StatusConflict, fmt.Sprintf("Conflict: %v", err))
+		return
+	}
+	s.reqLogf(r, "ERROR datastore: %v", err)
+	writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+}
+
+// errJSONTooDeep is returned by decodeJSON when a body nests objects or arrays
+// more deeply than the server allows.
+var errJSONTooDeep = errors.New("JSON nesting too deep")
+
+// decodeJSON reads the request body and decodes it into v. Before decoding, the
+// body is scanned token by token to make sure it doesn't nest deeper than
+// s.maxJSONDepth, so a malicious payload can't make the decoder recurse forever.
+func (s *server) decodeJSON(r *http.Request, v any) error {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if err := checkJSONDepth(data, s.maxJSONDepth); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// unknownFieldError is returned by decodeItemJSON for a body with a field
+// Item doesn't have.
+type unknownFieldError struct {
+	field string
+}
+
+func (e unknownFieldError) Error() string {
+	return fmt.Sprintf("unknown field %q", e.field)
+}
+
+// errNotObject is returned by decodeItemJSON when the body is valid JSON, but
+// something other than an object, like [1,2,3] or "hello".
+var errNotObject = errors.New("expected a JSON object")
+
+// decodeItemJSON is decodeJSON for the body of a single-item endpoint. The
+// body has to be a JSON object, and must not carry any fields Item doesn't
+// have: normally those are quietly dropped, so a misspelled "naem" would lose
+// the name without the client ever finding out.
+func (s *server) decodeItemJSON(r *http.Request, item *Item) error {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if err := checkJSONDepth(data, s.maxJSONDepth); err != nil {
+		return err
+	}
+	// Anything but an object would otherwise fail with a message about
+	// unmarshaling an array or string into Item, which says little to a client.
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] != '{' {
+		return errNotObject
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(item)
+	// The decoder reports unknown fields only through the message, like
+	// `json: unknown field "naem"`.
+	if field, ok := strings.CutPrefix(fmt.Sprint(err), "json: unknown field "); ok {
+		return unknownFieldError{field: strings.Trim(field, `"`)}
+	}
+	if err == nil && dec.More() {
+		// json.Unmarshal refuses anything after the value, so do the same.
+		return errors.New("unexpected data after JSON value")
+	}
+	return err
+}
+
+// checkJSONDepth walks the tokens of a JSON document and returns errJSONTooDeep
+// as soon as the nesting of objects and arrays exceeds max. The token stream is
+// read iteratively, so this check itself never recurses.
+func checkJSONDepth(data []byte, max int) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+			if depth > max {
+				return errJSONTooDeep
+			}
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+}
+
+// defaultMaxBodyBytes is the largest request body limitBody lets through when
+// MAX_BODY_BYTES isn't set: 1 MiB, far more than any item needs.
+const defaultMaxBodyBytes = 1 << 20
+
+// maxBodyBytesFromEnv reads the request body size limit from MAX_BODY_BYTES.
+// A missing or unusable value gets the default.
+func maxBodyBytesFromEnv() int64 {
+	n, err := strconv.ParseInt(os.Getenv("MAX_BODY_BYTES"), 10, 64)
+	if err != nil || n <= 0 {
+		return defaultMaxBodyBytes
+	}
+	return n
+}
+
+// limitBody caps how much of r's body can be read at s.maxBodyBytes. Without
+// it, decodeJSON would read a multi-gigabyte body into memory in full; with
+// it, the read fails with an *http.MaxBytesError once the limit is passed.
+func (s *server) limitBody(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxBodyBytes)
+}
+
+// writeDecodeError answers a failed decodeJSON call: 413 Request Entity Too
+// Large for a body over the limitBody cap, and 400 Bad Request otherwise.
+func writeDecodeError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request entity too large: the limit is %d bytes", tooLarge.Limit))
+		return
+	}
+	writeJSONError(w, http.StatusBadRequest, decodeErrorMessage(err))
+}
+
+// decodeErrorMessage picks the client-facing message for a failed decodeJSON call.
+func decodeErrorMessage(err error) string {
+	if errors.Is(err, errJSONTooDeep) {
+		return "Bad request: JSON nesting too deep"
+	}
+	if errors.Is(err, errNotObject) {
+		return "Bad request: expected a JSON object"
+	}
+	var unknown unknownFieldError
+	if errors.As(err, &unknown) {
+		return fmt.Sprintf("Bad request: %v", unknown)
+	}
+	return "Bad request: invalid JSON"
+}
+
+// addrFromEnv returns the address to listen on: the ADDR environment variable
+// (like ":9090" or "127.0.0.1:8080"), or ":8080" when it isn't set.
+func addrFromEnv() string {
+	if addr := os.Getenv("ADDR"); addr != "" {
+		return addr
+	}
+	return ":8080"
+}
+
+// defaultShutdownTimeout is how long shutdown waits for requests to finish
+// when SHUTDOWN_TIMEOUT isn't set.
+const defaultShutdownTimeout = 5 * time.Second
+
+// shutdownTimeoutFromEnv reads how long shutdown waits for active requests
+// from SHUTDOWN_TIMEOUT, a Go duration like "15s". A missing or unusable value
+// gets the default; the second result says what was wrong with it, if anything.
+func shutdownTimeoutFromEnv() (time.Duration, error) {
+	return durationFromEnv("SHUTDOWN_TIMEOUT", defaultShutdownTimeout)
+}
+
 // main is the entry point for the application.
 func main() {
+	// Read the command-line flags.
+	strictPutID := flag.Bool("strict-put-id", false, "reject PUT bodies whose id differs from the URL instead of overriding it")
+	compositeKey := flag.Bool("composite-key", false, "require every item to have a unique name and age, and allow lookups by them")
+	trustProxy := flag.Bool("trust-proxy", false, "honor X-Forwarded-Prefix and X-Forwarded-For from a proxy in front of the server")
+	network := flag.String("network", "tcp", "network to listen on: tcp (platform default), tcp4 or tcp6")
+	defaultSort := flag.String("default-sort", "id", "order GET /items uses when the client gives no sort, like name or -created_at")
+	weakETags := flag.Bool("weak-etags", false, "hand out weak ETags (W/\"...\") for items instead of strong ones")
+	flag.Parse()
+
 	// Create a new instance of our server with all its dependencies.
 	server := newServer()
-	server.logger.Println("Server starting on port :8080...")
+	server.strictPutID = *strictPutID
+	server.trustProxy = *trustProxy
+	server.weakETags = *weakETags
+	// A bad default order would fail every listing, so refuse to start with one.
+	order, err := parseSortSpec(*defaultSort)
+	if err != nil {
+		server.fatalf("Cannot use -default-sort: %v", err)
+	}
+	server.defaultSort = order
+	if *compositeKey {
+		if err := server.enableCompositeKeys(); err != nil {
+			server.fatalf("Cannot enable composite keys: %v", err)
+		}
+	}
+	if server.rateLimiter = rateLimiterFromEnv(); server.rateLimiter != nil {
+		server.logf("Limiting each client IP to %g requests per second, in bursts of up to %g",
+			server.rateLimiter.rate, server.rateLimiter.burst)
+	}
+	if server.limiter = requestLimiterFromEnv(); server.limiter != nil {
+		server.logf("Serving up to %d requests at once, queueing up to %d more for %v",
+			cap(server.limiter.slots), server.limiter.maxQueue, server.limiter.wait)
+	}
+	server.replicas = replicasFromEnv()
+	if n, err := strconv.Atoi(os.Getenv("IMPORT_WORKERS")); err == nil && n > 0 {
+		server.importWorkers = n
+	}
+	server.warnIfReplicated()
+	shutdownTimeout, err := shutdownTimeoutFromEnv()
+	if err != nil {
+		server.logf("WARNING ignoring SHUTDOWN_TIMEOUT (%v), using %v", err, shutdownTimeout)
+	}
+	server.logf("Shutdown will wait up to %v for active requests", shutdownTimeout)
+	addr := addrFromEnv()
+
+	// Open the listener up front, so a bad address or an unavailable IP stack
+	// stops the server before it claims to be running.
+	ln, err := listen(*network, addr)
+	if err != nil {
+		server.fatalf("Cannot listen on %s (%s): %v", addr, *network, err)
+	}
 
 	// --- Graceful Shutdown Setup ---
 
 	// We create a custom http.Server to have finer control over its behavior.
 	srv := &http.Server{
-		Addr:    ":8080",
+		Addr:    addr,
 		Handler: server.router, // Our chi router is the handler.
 	}
+	// With TLS_CERT and TLS_KEY set, we serve HTTPS instead of plain HTTP.
+	files, useTLS, err := tlsFilesFromEnv()
+	if err != nil {
+		server.fatalf("Cannot set up TLS: %v", err)
+	}
+	mode := "HTTP"
+	if useTLS {
+		if srv.TLSConfig, err = files.config(); err != nil {
+			server.fatalf("Cannot load TLS certificate %s and key %s: %v", files.cert, files.key, err)
+		}
+		mode = "HTTPS"
+	}
+	server.logf("Server starting on %s (%s, %s)...", ln.Addr(), addrFamily(ln.Addr()), mode)
+	// Timeouts stop a client from holding a connection open indefinitely.
+	timeouts, errs := serverTimeoutsFromEnv()
+	for _, err := range errs {
+		server.logf("WARNING ignoring %v", err)
+	}
+	timeouts.apply(srv)
+	server.logf("Connection timeouts: read %v, write %v, idle %v", timeouts.read, timeouts.write, timeouts.idle)
+	// The write timeout runs from the end of the request, so it also caps how
+	// long a handler has to answer.
+	if timeouts.write <= slowDuration {
+		server.logf("WARNING WRITE_TIMEOUT %v does not exceed the %v GET /slow takes, so its response will be cut off", timeouts.write, slowDuration)
+	}
+
+	// The memory guard is off unless a heap limit is given, in megabytes.
+	if limit, err := strconv.ParseUint(os.Getenv("HEAP_LIMIT_MB"), 10, 64); err == nil && limit > 0 {
+		server.heapLimit = limit << 20
+		server.logf("Refusing writes while heap usage is above %d MB", limit)
+		go server.watchMemory(context.Background(), 5*time.Second)
+	}
+
+	// Changes are written to the data file every FLUSH_INTERVAL, if set, as
+	// well as on shutdown. stopFlushing ends the loop before the final save.
+	flushCtx, stopFlushing := context.WithCancel(context.Background())
+	flushDone := make(chan struct{})
+	interval, err := flushIntervalFromEnv()
+	if err != nil {
+		server.logf("WARNING ignoring FLUSH_INTERVAL (%v), saving only on shutdown", err)
+	}
+	if interval > 0 && server.dataFile != "" {
+		server.logf("Flushing changes to %s at most every %v", server.dataFile, interval)
+		go func() {
+			defer close(flushDone)
+			server.flushLoop(flushCtx, interval)
+		}()
+	} else {
+		close(flushDone)
+	}
 
 	// Run the server in a goroutine so that it doesn't block the main thread.
 	// This allows the main thread to listen for shutdown signals.
 	go func() {
-		// srv.ListenAndServe() starts the server. It's a blocking call.
-		// We check for any error returned by ListenAndServe, ignoring ErrServerClosed,
-		// which is the expected error when we gracefully shut down the server.
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			server.logger.Fatalf("Cannot start server: %v", err)
+		// serve() starts serving on our listener, over TLS or not. It's a
+		// blocking call. We check for any error it returns, ignoring
+		// ErrServerClosed, which is the expected error when we gracefully shut
+		// down the server.
+		if err := serve(srv, ln); err != nil && err != http.ErrServerClosed {
+			server.fatalf("Cannot start server: %v", err)
 		}
 	}() // The `()` immediately invokes the anonymous function.
 
+	// srv.Shutdown closes the main listener straight away, so the drain progress
+	// can only be watched during shutdown through a separate admin listener.
+	var adminSrv *http.Server
+	if addr := os.Getenv("ADMIN_ADDR"); addr != "" {
+		adminMux := http.NewServeMux()
+		adminMux.Handle("GET /admin/draining", server.handleDraining())
+		adminSrv = &http.Server{Addr: addr, Handler: server.trackInFlight(server.closeWhenDraining(adminMux))}
+		timeouts.apply(adminSrv)
+		server.logf("Admin listener starting on %s...", addr)
+		go func() {
+			if err := adminSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				server.fatalf("Cannot start admin listener: %v", err)
+			}
+		}()
+	}
+
 	// Create a channel to receive OS signals. We buffer it with a size of 1.
 	quit := make(chan os.Signal, 1)
-	// signal.Notify redirects incoming os.Interrupt signals (like Ctrl+C) to our `quit` channel.
-	signal.Notify(quit, os.Interrupt)
+	// signal.Notify redirects incoming signals to our `quit` channel: os.Interrupt
+	// (like Ctrl+C), SIGTERM (what Docker and Kubernetes send to stop a
+	// container) and SIGHUP (the terminal going away).
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 
 	// Block the main goroutine until a signal is received on the `quit` channel.
-	<-quit
-	server.logger.Println("Shutdown signal received, initiating graceful shutdown...")
+	sig := <-quit
+	server.logf("Shutdown signal received (%v), initiating graceful shutdown...", sig)
+	server.draining.Store(true)
 
-	// Create a context with a 5-second timeout to give active connections time to finish.
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	// Create a context with a timeout to give active connections time to finish.
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	// `defer cancel()` ensures the context is canceled to release its resources,
 	// no matter how the function exits.
 	defer cancel()
 
-	// srv.Shutdown() gracefully shuts down the server.
+	// server.shutdown() gracefully shuts down the server.
 	// It stops accepting new connections and waits for active connections to finish.
-	if err := srv.Shutdown(ctx); err != nil {
-		server.logger.Fatalf("Server forced to shutdown: %v", err)
+	// Even if that runs out of time, the data file is saved before we exit.
+	shutdownErr := server.shutdown(ctx, srv, stopFlushing, flushDone)
+
+	// The drain is over, so the admin listener has nothing left to report.
+	if adminSrv != nil {
+		adminSrv.Shutdown(ctx)
 	}
 
-	server.logger.Println("Server exited gracefully")
+	if shutdownErr != nil {
+		server.fatalf("Server exited after cutting off active requests")
+	}
+	server.logf("Server exited gracefully")
 }
@@ -3,10 +3,15 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 // TestHandleCreateItem is a test function for our handleCreateItem handler.
@@ -62,3 +67,935 @@ func TestHandleCreateItem(t *testing.T) {
 			responseItem, itemPayload)
 	}
 }
+
+// TestRegisterInvalidPattern checks that a malformed route pattern is reported
+// as a clear error naming the route, instead of crashing with chi's panic.
+func TestRegisterInvalidPattern(t *testing.T) {
+	server := newServer()
+
+	// chi requires every pattern to begin with a '/', so this one is invalid.
+	err := server.register(route{http.MethodGet, "items", func(w http.ResponseWriter, r *http.Request) {}})
+	if err == nil {
+		t.Fatal("expected an error for an invalid pattern, got nil")
+	}
+
+	// The error should tell us exactly which route was at fault.
+	if !strings.Contains(err.Error(), `GET "items"`) {
+		t.Errorf("error does not name the failing route: %v", err)
+	}
+}
+
+// TestHandleCreateItemDeepJSON checks that a body nested far beyond the
+// configured depth is rejected with a 400 before it's ever decoded.
+func TestHandleCreateItemDeepJSON(t *testing.T) {
+	server := newServer()
+
+	// Build something like {"name":[[[[...]]]]} nested well past the limit.
+	depth := server.maxJSONDepth + 10
+	body := `{"name":` + strings.Repeat("[", depth) + strings.Repeat("]", depth) + `}`
+
+	req := httptest.NewRequest("POST", "/items", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+	if !strings.Contains(rr.Body.String(), "nesting too deep") {
+		t.Errorf("handler returned unexpected body: %q", rr.Body.String())
+	}
+}
+
+// TestHandleCreateItemTooLarge checks that create and update bodies over the
+// 1 MiB default limit get a 413 instead of being read into memory.
+func TestHandleCreateItemTooLarge(t *testing.T) {
+	server := newServer()
+	server.putItem(Item{ID: 1, Name: "Alice", Age: 30})
+	body := `{"name":"` + strings.Repeat("a", defaultMaxBodyBytes) + `","age":30}`
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest("POST", "/items", strings.NewReader(body)),
+		httptest.NewRequest("PUT", "/items/1", strings.NewReader(body)),
+	} {
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s %s: handler returned wrong status code: got %v want %v",
+				req.Method, req.URL.Path, status, http.StatusRequestEntityTooLarge)
+		}
+	}
+	if got := storedItem(server, 1); got.Name != "Alice" {
+		t.Errorf("item 1 changed by an oversized update: %+v", got)
+	}
+}
+
+// TestHandleCreateItemUnknownField checks that a misspelled field is refused
+// with a 400 naming it, rather than silently dropped.
+func TestHandleCreateItemUnknownField(t *testing.T) {
+	server := newServer()
+	server.putItem(Item{ID: 1, Name: "Alice", Age: 30})
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest("POST", "/items", strings.NewReader(`{"id":2,"naem":"typo","age":30}`)),
+		httptest.NewRequest("PUT", "/items/1", strings.NewReader(`{"naem":"typo","age":30}`)),
+	} {
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("%s %s: handler returned wrong status code: got %v want %v",
+				req.Method, req.URL.Path, status, http.StatusBadRequest)
+		}
+		if !strings.Contains(rr.Body.String(), `unknown field \"naem\"`) {
+			t.Errorf("%s %s: handler returned unexpected body: %q", req.Method, req.URL.Path, rr.Body.String())
+		}
+	}
+}
+
+// TestHandleCreateItemNotObject checks that a body that's valid JSON but not
+// an object gets a 400 saying an object was expected.
+func TestHandleCreateItemNotObject(t *testing.T) {
+	server := newServer()
+
+	for _, body := range []string{`[1,2,3]`, `"hello"`} {
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, httptest.NewRequest("POST", "/items", strings.NewReader(body)))
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", body, status, http.StatusBadRequest)
+		}
+		if !strings.Contains(rr.Body.String(), "expected a JSON object") {
+			t.Errorf("%s: handler returned unexpected body: %q", body, rr.Body.String())
+		}
+	}
+}
+
+// TestHandleBulkPatchItems checks that PATCH /items merges the patch into every
+// listed item and reports IDs that don't exist without failing the request.
+func TestHandleBulkPatchItems(t *testing.T) {
+	server := newServer()
+	server.putItem(Item{ID: 1, Name: "Alice", Age: 30})
+	server.putItem(Item{ID: 2, Name: "Bob", Age: 40})
+	server.putItem(Item{ID: 3, Name: "Carol", Age: 50})
+
+	// Only the age is patched, so names must be preserved.
+	body := `{"ids":[1,2,99],"patch":{"age":18}}`
+	req := httptest.NewRequest("PATCH", "/items", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	var results []bulkPatchResult
+	if err := json.NewDecoder(rr.Body).Decode(&results); err != nil {
+		t.Fatalf("could not decode response body: %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+	for i, want := range []Item{{ID: 1, Name: "Alice", Age: 18}, {ID: 2, Name: "Bob", Age: 18}} {
+		if results[i].Status != "updated" || results[i].Item == nil || results[i].Item.content() != want {
+			t.Errorf("result %d: got %+v want updated %+v", i, results[i], want)
+		}
+		if storedItem(server, want.ID).content() != want {
+			t.Errorf("stored item: got %+v want %+v", storedItem(server, want.ID), want)
+		}
+	}
+	if results[2].ID != 99 || results[2].Status != "not_found" {
+		t.Errorf("missing ID result: got %+v", results[2])
+	}
+
+	// Item 3 wasn't listed and must be untouched.
+	if storedItem(server, 3).Age != 50 {
+		t.Errorf("unlisted item was modified: %+v", storedItem(server, 3))
+	}
+}
+
+// TestItemJSONCacheInvalidatedOnUpdate checks that after an item is read (and its
+// JSON cached) and then updated, the next read returns the new data.
+func TestItemJSONCacheInvalidatedOnUpdate(t *testing.T) {
+	server := newServer()
+	server.putItem(Item{ID: 1, Name: "Alice", Age: 30})
+
+	get := func() Item {
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, httptest.NewRequest("GET", "/items/1", nil))
+		var item Item
+		if err := json.NewDecoder(rr.Body).Decode(&item); err != nil {
+			t.Fatalf("could not decode response body: %v", err)
+		}
+		return item
+	}
+
+	// The first read fills the cache.
+	if got := get(); got.Name != "Alice" {
+		t.Fatalf("got %+v before update", got)
+	}
+
+	body := `{"name":"Alice Smith","age":31}`
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("PUT", "/items/1", strings.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("update returned %v", rr.Code)
+	}
+
+	// The second read must not see the cached pre-update JSON.
+	want := Item{ID: 1, Name: "Alice Smith", Age: 31}
+	if got := get(); got.content() != want {
+		t.Errorf("got stale item after update: got %+v want %+v", got, want)
+	}
+}
+
+// BenchmarkHandleGetItem compares serving an item from the JSON cache against
+// encoding it on every request.
+func BenchmarkHandleGetItem(b *testing.B) {
+	server := newServer()
+	server.putItem(Item{ID: 1, Name: "Benchmark Item", Age: 42})
+	req := httptest.NewRequest("GET", "/items/1", nil)
+
+	b.Run("cached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			server.router.ServeHTTP(httptest.NewRecorder(), req)
+		}
+	})
+	b.Run("uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			delete(server.itemJSON, 1) // Force a fresh encode every time.
+			server.router.ServeHTTP(httptest.NewRecorder(), req)
+		}
+	})
+}
+
+// TestHandleChangeItemIDMismatch checks both ways of handling a PUT whose body
+// ID disagrees with the URL: lenient mode uses the URL's ID, strict mode
+// rejects the request.
+func TestHandleChangeItemIDMismatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		strict     bool
+		wantStatus int
+		wantName   string // The name stored under ID 1 afterwards.
+	}{
+		{"lenient", false, http.StatusOK, "Changed"},
+		{"strict", true, http.StatusBadRequest, "Alice"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newServer()
+			server.strictPutID = tt.strict
+			server.putItem(Item{ID: 1, Name: "Alice", Age: 30})
+
+			body := `{"id":2,"name":"Changed","age":31}`
+			rr := httptest.NewRecorder()
+			server.router.ServeHTTP(rr, httptest.NewRequest("PUT", "/items/1", strings.NewReader(body)))
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("got status %v want %v", rr.Code, tt.wantStatus)
+			}
+			if got := storedItem(server, 1).Name; got != tt.wantName {
+				t.Errorf("item 1 has name %q, want %q", got, tt.wantName)
+			}
+			if _, found := server.store.Get(2); found {
+				t.Error("the body's ID was used to store the item")
+			}
+		})
+	}
+}
+
+// TestHandleGetItemExpand checks that ?expand=age_group adds the derived field,
+// that it's absent otherwise, and that unknown expansions are rejected.
+func TestHandleGetItemExpand(t *testing.T) {
+	server := newServer()
+	server.putItem(Item{ID: 1, Name: "Alice", Age: 30})
+	server.putItem(Item{ID: 2, Name: "Bobby", Age: 12})
+
+	tests := []struct {
+		url        string
+		wantStatus int
+		wantGroup  string // Empty means the field must be absent.
+	}{
+		{"/items/1?expand=age_group", http.StatusOK, "adult"},
+		{"/items/2?expand=age_group", http.StatusOK, "minor"},
+		{"/items/1", http.StatusOK, ""},
+		{"/items/1?expand=shoe_size", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, httptest.NewRequest("GET", tt.url, nil))
+		if rr.Code != tt.wantStatus {
+			t.Errorf("%s: got status %v want %v", tt.url, rr.Code, tt.wantStatus)
+			continue
+		}
+		if rr.Code != http.StatusOK {
+			continue
+		}
+
+		var body map[string]any
+		if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+			t.Fatalf("%s: could not decode response body: %v", tt.url, err)
+		}
+		got, present := body["age_group"]
+		if tt.wantGroup == "" && present {
+			t.Errorf("%s: age_group = %v, want it absent", tt.url, got)
+		}
+		if tt.wantGroup != "" && got != tt.wantGroup {
+			t.Errorf("%s: age_group = %v, want %q", tt.url, got, tt.wantGroup)
+		}
+	}
+}
+
+// TestHandleCompareAndSetItem checks that a compare-and-set succeeds when the
+// expected value matches and otherwise returns 409 with the current value.
+func TestHandleCompareAndSetItem(t *testing.T) {
+	server := newServer()
+	server.putItem(Item{ID: 1, Name: "Alice", Age: 30})
+
+	cas := func(body string) (int, Item) {
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, httptest.NewRequest("POST", "/items/1/cas", strings.NewReader(body)))
+		var item Item
+		json.NewDecoder(rr.Body).Decode(&item)
+		return rr.Code, item
+	}
+
+	// The expected value matches, so the swap goes through.
+	status, got := cas(`{"expected":{"name":"Alice","age":30},"new":{"name":"Alice","age":31}}`)
+	want := Item{ID: 1, Name: "Alice", Age: 31}
+	if status != http.StatusOK || got.content() != want {
+		t.Errorf("matching CAS: got %v %+v want %v %+v", status, got, http.StatusOK, want)
+	}
+
+	// Replaying the same request now fails, since the age is no longer 30.
+	status, got = cas(`{"expected":{"name":"Alice","age":30},"new":{"name":"Alice","age":99}}`)
+	if status != http.StatusConflict || got.content() != want {
+		t.Errorf("stale CAS: got %v %+v want %v %+v", status, got, http.StatusConflict, want)
+	}
+	if storedItem(server, 1).content() != want {
+		t.Errorf("stale CAS modified the item: %+v", storedItem(server, 1))
+	}
+}
+
+// TestHandleDeleteItem creates an item, deletes it, and checks that it can no
+// longer be fetched.
+func TestHandleDeleteItem(t *testing.T) {
+	server := newServer()
+
+	body := `{"id":101,"name":"Test Item","age":999}`
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("POST", "/items", strings.NewReader(body)))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create: got %v want %v", rr.Code, http.StatusCreated)
+	}
+
+	rr = httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("DELETE", "/items/101", nil))
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("delete: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+	if rr.Body.Len() != 0 {
+		t.Errorf("delete returned a body: %q", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("GET", "/items/101", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("get after delete: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+
+	// Deleting it a second time finds nothing to delete.
+	rr = httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("DELETE", "/items/101", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("second delete: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+// TestHandleListItems checks that the list is ordered by ID, and that an empty
+// datastore is listed as "items": [] rather than null.
+func TestHandleListItems(t *testing.T) {
+	server := newServer()
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("GET", "/items", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("empty list: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if got := strings.TrimSpace(rr.Body.String()); got != `{"items":[],"total":0,"limit":20,"offset":0}` {
+		t.Errorf("empty list: got body %q", got)
+	}
+
+	for _, id := range []int{3, 1, 2} {
+		server.putItem(Item{ID: id, Name: "Item", Age: id * 10})
+	}
+	rr = httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("GET", "/items", nil))
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("got Content-Type %q want application/json", ct)
+	}
+
+	var list itemList
+	if err := json.NewDecoder(rr.Body).Decode(&list); err != nil {
+		t.Fatalf("could not decode response body: %v", err)
+	}
+	items := list.Items
+	if len(items) != 3 || items[0].ID != 1 || items[1].ID != 2 || items[2].ID != 3 {
+		t.Errorf("items are not listed in ID order: %+v", items)
+	}
+}
+
+// TestHandleListItemsCounts checks that a filtered list reports both the total
+// number of items and how many passed the filter.
+func TestHandleListItemsCounts(t *testing.T) {
+	server := newServer()
+	for id, age := range map[int]int{1: 10, 2: 20, 3: 30, 4: 40} {
+		server.putItem(Item{ID: id, Name: "Item", Age: age})
+	}
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("GET", "/items?min_age=25", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if got := rr.Header().Get("X-Total-Count"); got != "4" {
+		t.Errorf("wrong X-Total-Count: got %q want 4", got)
+	}
+	if got := rr.Header().Get("X-Filtered-Count"); got != "2" {
+		t.Errorf("wrong X-Filtered-Count: got %q want 2", got)
+	}
+
+	var list itemList
+	if err := json.NewDecoder(rr.Body).Decode(&list); err != nil {
+		t.Fatalf("could not decode response body: %v", err)
+	}
+	items := list.Items
+	if len(items) != 2 || items[0].ID != 3 || items[1].ID != 4 {
+		t.Errorf("filter returned the wrong items: %+v", items)
+	}
+
+	rr = httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("GET", "/items?min_age=old", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid min_age: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
+
+// TestHandleListItemsByIDs checks listing a chosen set of items, with the
+// missing ones named in a header.
+func TestHandleListItemsByIDs(t *testing.T) {
+	server := newServer()
+	for _, id := range []int{1, 2, 3} {
+		server.putItem(Item{ID: id, Name: "Item", Age: id})
+	}
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("GET", "/items?ids=3,4,1,9,3", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if got := rr.Header().Get("X-Missing-IDs"); got != "4,9" {
+		t.Errorf("wrong X-Missing-IDs: got %q want 4,9", got)
+	}
+	var list itemList
+	if err := json.NewDecoder(rr.Body).Decode(&list); err != nil {
+		t.Fatalf("could not decode response body: %v", err)
+	}
+	items := list.Items
+	if len(items) != 2 || items[0].ID != 3 || items[1].ID != 1 {
+		t.Errorf("expected items 3 and 1 in the order asked for, got %+v", items)
+	}
+
+	rr = httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("GET", "/items?ids=1,two", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("invalid ids: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
+
+// TestHandleListItemsPaging checks the default page, an explicit page, a limit
+// above the cap and bad paging values.
+func TestHandleListItemsPaging(t *testing.T) {
+	server := newServer()
+	for id := 1; id <= 150; id++ {
+		server.putItem(Item{ID: id, Name: "Item", Age: id})
+	}
+
+	list := func(query string) itemList {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, httptest.NewRequest("GET", "/items"+query, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: handler returned wrong status code: got %v want %v", query, rr.Code, http.StatusOK)
+		}
+		var got itemList
+		if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+			t.Fatalf("%s: could not decode response body: %v", query, err)
+		}
+		return got
+	}
+
+	// By default the first 20 items are listed.
+	got := list("")
+	if len(got.Items) != defaultPageLimit || got.Items[0].ID != 1 || got.Total != 150 || got.Limit != defaultPageLimit || got.Offset != 0 {
+		t.Errorf("default page: got %d items from %d, total %d, limit %d, offset %d",
+			len(got.Items), got.Items[0].ID, got.Total, got.Limit, got.Offset)
+	}
+
+	// An explicit page starts at the offset, and the filters apply before paging.
+	got = list("?limit=5&offset=10&min_age=101")
+	if len(got.Items) != 5 || got.Items[0].ID != 111 || got.Total != 50 || got.Limit != 5 || got.Offset != 10 {
+		t.Errorf("explicit page: got %d items from %d, total %d, limit %d, offset %d",
+			len(got.Items), got.Items[0].ID, got.Total, got.Limit, got.Offset)
+	}
+
+	// A limit above the cap is brought down to it.
+	got = list("?limit=1000")
+	if len(got.Items) != maxPageLimit || got.Limit != maxPageLimit {
+		t.Errorf("clamped page: got %d items with limit %d, want %d", len(got.Items), got.Limit, maxPageLimit)
+	}
+
+	// An offset past the end gives an empty page, not an error.
+	if got = list("?offset=500"); len(got.Items) != 0 || got.Items == nil {
+		t.Errorf("page past the end: got %#v, want an empty list", got.Items)
+	}
+
+	for _, query := range []string{"?limit=0", "?limit=-1", "?limit=ten", "?offset=-5", "?offset=x"} {
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, httptest.NewRequest("GET", "/items"+query, nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %v want %v", query, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+// TestHandleListItemsNameSearch checks that name matches substrings of item
+// names, ignoring case.
+func TestHandleListItemsNameSearch(t *testing.T) {
+	server := newServer()
+	server.putItem(Item{ID: 1, Name: "Alice", Age: 30})
+	server.putItem(Item{ID: 2, Name: "Bob", Age: 40})
+	server.putItem(Item{ID: 3, Name: "Malik", Age: 50})
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("GET", "/items?name=LI", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	var list itemList
+	if err := json.NewDecoder(rr.Body).Decode(&list); err != nil {
+		t.Fatalf("could not decode response body: %v", err)
+	}
+	if len(list.Items) != 2 || list.Items[0].ID != 1 || list.Items[1].ID != 3 || list.Total != 2 {
+		t.Errorf("expected Alice and Malik, got %+v (total %d)", list.Items, list.Total)
+	}
+}
+
+// TestHandleListItemsSort checks sorting the listing by name ascending and age
+// descending, and that an unknown sort field is rejected.
+func TestHandleListItemsSort(t *testing.T) {
+	server := newServer()
+	server.putItem(Item{ID: 1, Name: "carol", Age: 30})
+	server.putItem(Item{ID: 2, Name: "Alice", Age: 50})
+	server.putItem(Item{ID: 3, Name: "Bob", Age: 40})
+
+	tests := []struct {
+		url     string
+		wantIDs []int
+	}{
+		{"/items", []int{1, 2, 3}},
+		{"/items?sort=name", []int{2, 3, 1}},
+		{"/items?sort=-age", []int{2, 3, 1}},
+		{"/items?sort=age", []int{1, 3, 2}},
+		{"/items?sort=-id", []int{3, 2, 1}},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, httptest.NewRequest("GET", tt.url, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: handler returned wrong status code: got %v want %v", tt.url, rr.Code, http.StatusOK)
+		}
+		var list itemList
+		if err := json.NewDecoder(rr.Body).Decode(&list); err != nil {
+			t.Fatalf("%s: could not decode response body: %v", tt.url, err)
+		}
+		var gotIDs []int
+		for _, item := range list.Items {
+			gotIDs = append(gotIDs, item.ID)
+		}
+		if !slices.Equal(gotIDs, tt.wantIDs) {
+			t.Errorf("%s: got IDs %v want %v", tt.url, gotIDs, tt.wantIDs)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("GET", "/items?sort=height", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("unknown sort field: got %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
+
+// TestHandleListItemsDefaultSort checks that a configured default order is
+// used when the client gives no sort, and that an explicit sort still wins.
+func TestHandleListItemsDefaultSort(t *testing.T) {
+	server := newServer()
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	server.putItem(Item{ID: 1, Name: "Alice", Age: 30, CreatedAt: start.Add(time.Hour)})
+	server.putItem(Item{ID: 2, Name: "Bob", Age: 40, CreatedAt: start.Add(3 * time.Hour)})
+	server.putItem(Item{ID: 3, Name: "Carol", Age: 50, CreatedAt: start.Add(2 * time.Hour)})
+
+	var err error
+	if server.defaultSort, err = parseSortSpec("-created_at"); err != nil {
+		t.Fatalf("could not parse default sort: %v", err)
+	}
+
+	tests := []struct {
+		url     string
+		wantIDs []int
+	}{
+		{"/items", []int{2, 3, 1}},
+		{"/items?sort=id", []int{1, 2, 3}},
+		{"/items?ids=1,3", []int{1, 3}},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, httptest.NewRequest("GET", tt.url, nil))
+		var list itemList
+		if err := json.NewDecoder(rr.Body).Decode(&list); err != nil {
+			t.Fatalf("%s: could not decode response body: %v", tt.url, err)
+		}
+		var gotIDs []int
+		for _, item := range list.Items {
+			gotIDs = append(gotIDs, item.ID)
+		}
+		if !slices.Equal(gotIDs, tt.wantIDs) {
+			t.Errorf("%s: got IDs %v want %v", tt.url, gotIDs, tt.wantIDs)
+		}
+	}
+
+	if _, err := parseSortSpec("-height"); err == nil {
+		t.Errorf("expected an error for an unknown default sort field")
+	}
+}
+
+// TestHandleGetItemField checks fetching single fields of an item, plus the
+// unknown-field and missing-item errors.
+func TestHandleGetItemField(t *testing.T) {
+	server := newServer()
+	server.putItem(Item{ID: 1, Name: "Alice", Age: 42})
+
+	tests := []struct {
+		url        string
+		wantStatus int
+		wantBody   string
+	}{
+		{"/items/1/name", http.StatusOK, `"Alice"`},
+		{"/items/1/age", http.StatusOK, `42`},
+		{"/items/1/height", http.StatusBadRequest, ""},
+		{"/items/2/name", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, httptest.NewRequest("GET", tt.url, nil))
+		if rr.Code != tt.wantStatus {
+			t.Errorf("%s: got status %v want %v", tt.url, rr.Code, tt.wantStatus)
+		}
+		if got := strings.TrimSpace(rr.Body.String()); tt.wantBody != "" && got != tt.wantBody {
+			t.Errorf("%s: got body %s want %s", tt.url, got, tt.wantBody)
+		}
+	}
+
+	// The avatar route is more specific than {field}, so it must still win.
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("GET", "/items/1/avatar", nil))
+	if strings.Contains(rr.Body.String(), "unknown field") {
+		t.Errorf("/items/1/avatar was routed to the field handler")
+	}
+}
+
+// TestConcurrentCreates fires 100 create requests at once. Run it with
+// `go test -race` to have the race detector check the datastore locking.
+func TestConcurrentCreates(t *testing.T) {
+	server := newServer()
+
+	var wg sync.WaitGroup
+	for id := 1; id <= 100; id++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			body, _ := json.Marshal(Item{ID: id, Name: "Concurrent", Age: id})
+			rr := httptest.NewRecorder()
+			server.router.ServeHTTP(rr, httptest.NewRequest("POST", "/items", bytes.NewReader(body)))
+			if rr.Code != http.StatusCreated {
+				t.Errorf("create %d: got %v want %v", id, rr.Code, http.StatusCreated)
+			}
+		}(id)
+	}
+	wg.Wait()
+
+	if got := len(server.store.List()); got != 100 {
+		t.Errorf("datastore holds %d items, want 100", got)
+	}
+}
+
+// TestHandleCreateItemAutoID checks that items posted without an ID are given
+// sequential IDs, skipping any a client already picked.
+func TestHandleCreateItemAutoID(t *testing.T) {
+	server := newServer()
+
+	create := func(body string) Item {
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, httptest.NewRequest("POST", "/items", strings.NewReader(body)))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("create %s: got %v want %v", body, rr.Code, http.StatusCreated)
+		}
+		var item Item
+		if err := json.NewDecoder(rr.Body).Decode(&item); err != nil {
+			t.Fatalf("could not decode response body: %v", err)
+		}
+		return item
+	}
+
+	if got := create(`{"name":"First","age":1}`); got.ID != 1 {
+		t.Errorf("first item got ID %d, want 1", got.ID)
+	}
+	if got := create(`{"id":0,"name":"Second","age":2}`); got.ID != 2 {
+		t.Errorf("second item got ID %d, want 2", got.ID)
+	}
+
+	// An explicit ID still works, and is then skipped by the counter.
+	if got := create(`{"id":3,"name":"Third","age":3}`); got.ID != 3 {
+		t.Errorf("explicit item got ID %d, want 3", got.ID)
+	}
+	if got := create(`{"name":"Fourth","age":4}`); got.ID != 4 {
+		t.Errorf("fourth item got ID %d, want 4", got.ID)
+	}
+}
+
+// TestItemValidate covers the rules enforced by Item.Validate.
+func TestItemValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		item    Item
+		wantErr string // Empty means the item is valid.
+	}{
+		{"valid item", Item{ID: 1, Name: "Alice", Age: 30}, ""},
+		{"zero age", Item{ID: 1, Name: "Newborn", Age: 0}, ""},
+		{"empty name", Item{ID: 1, Name: "", Age: 30}, "name must not be empty"},
+		{"negative age", Item{ID: 1, Name: "Alice", Age: -1}, "age must not be negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.item.Validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("got error %q, want none", err)
+			case tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr):
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestHandleCreateItemInvalid checks that an invalid item is refused with a
+// 422 and a JSON error body, and isn't stored.
+func TestHandleCreateItemInvalid(t *testing.T) {
+	server := newServer()
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("POST", "/items", strings.NewReader(`{"id":1,"name":"","age":30}`)))
+
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusUnprocessableEntity)
+	}
+	var body map[string]string
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatalf("could not decode response body: %v", err)
+	}
+	if body["error"] != "name must not be empty" {
+		t.Errorf("got error %q", body["error"])
+	}
+	if len(server.store.List()) != 0 {
+		t.Error("invalid item was stored")
+	}
+}
+
+// TestItemTimestamps checks that create sets both timestamps and update only
+// moves UpdatedAt, using a stubbed clock so the times are predictable.
+func TestItemTimestamps(t *testing.T) {
+	server := newServer()
+	clock := time.Date(2025, 6, 24, 12, 0, 0, 0, time.UTC)
+	server.now = func() time.Time { return clock }
+
+	send := func(method, url, body string) Item {
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, httptest.NewRequest(method, url, strings.NewReader(body)))
+		var item Item
+		if err := json.NewDecoder(rr.Body).Decode(&item); err != nil {
+			t.Fatalf("%s %s: could not decode response body: %v", method, url, err)
+		}
+		return item
+	}
+
+	created := send("POST", "/items", `{"id":1,"name":"Alice","age":30}`)
+	if !created.CreatedAt.Equal(clock) || !created.UpdatedAt.Equal(clock) {
+		t.Errorf("after create: got created_at %v updated_at %v, want both %v",
+			created.CreatedAt, created.UpdatedAt, clock)
+	}
+
+	// An hour later the item is updated. The client even tries to change
+	// created_at, which must be ignored.
+	later := clock.Add(time.Hour)
+	server.now = func() time.Time { return later }
+	updated := send("PUT", "/items/1", `{"name":"Alice","age":31,"created_at":"2000-01-01T00:00:00Z"}`)
+	if !updated.CreatedAt.Equal(clock) {
+		t.Errorf("after update: created_at = %v, want it kept at %v", updated.CreatedAt, clock)
+	}
+	if !updated.UpdatedAt.Equal(later) {
+		t.Errorf("after update: updated_at = %v, want %v", updated.UpdatedAt, later)
+	}
+}
+
+// TestErrorResponsesAreJSON checks that a 404 for a missing item comes back as
+// a JSON object with an "error" key, like every other error response.
+func TestErrorResponsesAreJSON(t *testing.T) {
+	server := newServer()
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("GET", "/items/404", nil))
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("got Content-Type %q want application/json", ct)
+	}
+	var body map[string]string
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatalf("could not decode response body: %v", err)
+	}
+	if body["error"] != "Item not found" {
+		t.Errorf("got error %q want %q", body["error"], "Item not found")
+	}
+}
+
+// TestHandleCreateItemConflict checks that creating an item with a taken ID
+// returns 409 along with the item that already has that ID.
+func TestHandleCreateItemConflict(t *testing.T) {
+	server := newServer()
+	existing := Item{ID: 1, Name: "Alice", Age: 30}
+	server.putItem(existing)
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("POST", "/items", strings.NewReader(`{"id":1,"name":"Bob","age":40}`)))
+
+	if status := rr.Code; status != http.StatusConflict {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusConflict)
+	}
+	var body createConflict
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatalf("could not decode response body: %v", err)
+	}
+	if body.Existing != existing || body.Error == "" {
+		t.Errorf("got %+v, want the existing item %+v and an error", body, existing)
+	}
+}
+
+// TestHandleCreateItemRequireAbsentName checks that require_absent_name blocks
+// a create while another item has that name, even under a different ID, and
+// lets it through otherwise.
+func TestHandleCreateItemRequireAbsentName(t *testing.T) {
+	server := newServer()
+	existing := Item{ID: 1, Name: "Alice", Age: 30}
+	server.putItem(existing)
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("POST", "/items?require_absent_name=Alice", strings.NewReader(`{"id":2,"name":"Alice","age":31}`)))
+	if status := rr.Code; status != http.StatusConflict {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusConflict)
+	}
+	var body createConflict
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatalf("could not decode response body: %v", err)
+	}
+	if body.Existing != existing {
+		t.Errorf("got existing %+v want %+v", body.Existing, existing)
+	}
+	if _, found := server.store.Get(2); found {
+		t.Errorf("item 2 was created despite the name collision")
+	}
+
+	rr = httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("POST", "/items?require_absent_name=Bob", strings.NewReader(`{"id":2,"name":"Bob","age":40}`)))
+	if status := rr.Code; status != http.StatusCreated {
+		t.Errorf("handler returned wrong status code for a free name: got %v want %v", status, http.StatusCreated)
+	}
+}
+
+// TestAddrFromEnv checks the listen address defaults to :8080 and can be
+// overridden with ADDR.
+func TestAddrFromEnv(t *testing.T) {
+	t.Setenv("ADDR", "")
+	if got := addrFromEnv(); got != ":8080" {
+		t.Errorf("default: got %q want :8080", got)
+	}
+
+	t.Setenv("ADDR", "127.0.0.1:9090")
+	if got := addrFromEnv(); got != "127.0.0.1:9090" {
+		t.Errorf("with ADDR set: got %q want 127.0.0.1:9090", got)
+	}
+}
+
+// TestShutdownTimeoutFromEnv checks the SHUTDOWN_TIMEOUT parsing, including the
+// fallback to the default on bad values.
+func TestShutdownTimeoutFromEnv(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "", want: defaultShutdownTimeout},
+		{value: "15s", want: 15 * time.Second},
+		{value: "1m30s", want: 90 * time.Second},
+		{value: "soon", want: defaultShutdownTimeout, wantErr: true},
+		{value: "-5s", want: defaultShutdownTimeout, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Setenv("SHUTDOWN_TIMEOUT", tt.value)
+		got, err := shutdownTimeoutFromEnv()
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("SHUTDOWN_TIMEOUT=%q: got %v, %v want %v (error: %v)", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// TestHandleSlowCanceled checks that /slow gives up as soon as its request is
+// canceled, rather than sleeping the full ten seconds.
+func TestHandleSlowCanceled(t *testing.T) {
+	server := newServer()
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	req := httptest.NewRequest("GET", "/slow", nil).WithContext(ctx)
+	rr := httptest.NewRecorder()
+	start := time.Now()
+	server.router.ServeHTTP(rr, req)
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("handler took %v to notice the cancellation", elapsed)
+	}
+	if status := rr.Code; status != statusClientClosedRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, statusClientClosedRequest)
+	}
+}
+
+// storedItem returns the item with the given ID straight from the server's
+// store, or the zero Item if there isn't one.
+func storedItem(s *server, id int) Item {
+	item, _ := s.store.Get(id)
+	return item
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// heapInUse reports the bytes of heap currently allocated by the process. It's
+// the default source of memory figures for the memory guard.
+func heapInUse() uint64 {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc
+}
+
+// checkMemory samples the heap and records whether it's above s.heapLimit.
+// Transitions are logged, so the log shows when writes started and stopped
+// being refused.
+func (s *server) checkMemory() {
+	if s.heapLimit == 0 {
+		return // The guard is disabled.
+	}
+	used := s.heapStats()
+	high := used >= s.heapLimit
+	if s.memoryHigh.Swap(high) != high {
+		if high {
+			s.logf("WARNING heap usage %d bytes crossed the limit of %d bytes, refusing writes", used, s.heapLimit)
+		} else {
+			s.logf("Heap usage back down to %d bytes, accepting writes again", used)
+		}
+	}
+}
+
+// watchMemory calls checkMemory every interval until ctx is canceled.
+// ReadMemStats briefly stops the world, so it's worth not calling it on every request.
+func (s *server) watchMemory(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.checkMemory()
+		}
+	}
+}
+
+// guardMemory is a middleware that answers write requests with a 503 Service
+// Unavailable while the heap is above the limit. Reads are still served, since
+// they don't grow the datastore, and so are admin requests: POST /admin/gc in
+// particular is how an operator gets the heap back under the limit.
+func (s *server) guardMemory(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.memoryHigh.Load() && isWrite(r.Method) && !strings.HasPrefix(r.URL.Path, "/admin/") {
+			writeJSONError(w, http.StatusServiceUnavailable, "Service unavailable: memory limit reached")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isWrite reports whether method is one that changes server state.
+func isWrite(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// heapFigures is a snapshot of the heap statistics reported by POST /admin/gc.
+type heapFigures struct {
+	HeapAlloc    uint64 `json:"heap_alloc"`
+	HeapInuse    uint64 `json:"heap_inuse"`
+	HeapIdle     uint64 `json:"heap_idle"`
+	HeapReleased uint64 `json:"heap_released"`
+	HeapObjects  uint64 `json:"heap_objects"`
+}
+
+// readHeapFigures takes a snapshot of the current heap statistics.
+func readHeapFigures() heapFigures {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return heapFigures{
+		HeapAlloc:    m.HeapAlloc,
+		HeapInuse:    m.HeapInuse,
+		HeapIdle:     m.HeapIdle,
+		HeapReleased: m.HeapReleased,
+		HeapObjects:  m.HeapObjects,
+	}
+}
+
+// gcReport is the body returned by POST /admin/gc.
+type gcReport struct {
+	Before heapFigures `json:"before"`
+	After  heapFigures `json:"after"`
+	// Freed is how much HeapAlloc went down. Memory that's still reachable
+	// can't be freed, so a small figure here hints at a leak rather than garbage.
+	Freed int64 `json:"freed"`
+}
+
+// handleForceGC handles requests to run the garbage collector right now and
+// hand freed memory back to the OS (e.g., POST /admin/gc), reporting the heap
+// figures from before and after.
+func (s *server) handleForceGC() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		before := readHeapFigures()
+		runtime.GC()
+		debug.FreeOSMemory()
+		after := readHeapFigures()
+
+		report := gcReport{
+			Before: before,
+			After:  after,
+			Freed:  int64(before.HeapAlloc) - int64(after.HeapAlloc),
+		}
+		s.reqLogf(r, "Forced garbage collection, freed %d bytes of heap", report.Freed)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
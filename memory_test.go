@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestGuardMemory checks that writes get a 503 while the (simulated) heap is
+// over the limit, that reads and admin requests keep working, and that writes
+// resume once it drops.
+func TestGuardMemory(t *testing.T) {
+	server := newServer()
+	server.putItem(Item{ID: 1, Name: "Alice", Age: 30})
+
+	// Inject a fake stats source instead of really filling up the heap.
+	var used uint64 = 2 << 20
+	server.heapLimit = 1 << 20
+	server.heapStats = func() uint64 { return used }
+	server.checkMemory()
+
+	create := func() int {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/items", strings.NewReader(`{"id":2,"name":"Bob","age":40}`))
+		server.router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if status := create(); status != http.StatusServiceUnavailable {
+		t.Errorf("create over the limit: got %v want %v", status, http.StatusServiceUnavailable)
+	}
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("GET", "/items/1", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("read over the limit: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	// Admin writes are let through, so an operator can still force a GC.
+	for _, path := range []string{"/admin/gc", "/admin/pause", "/admin/resume"} {
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, httptest.NewRequest("POST", path, nil))
+		if rr.Code != http.StatusOK {
+			t.Errorf("POST %s over the limit: got %v want %v", path, rr.Code, http.StatusOK)
+		}
+	}
+
+	// Once usage recovers, writes go through again.
+	used = 0
+	server.checkMemory()
+	if status := create(); status != http.StatusCreated {
+		t.Errorf("create after recovery: got %v want %v", status, http.StatusCreated)
+	}
+}
+
+// TestHandleForceGC checks that forcing a collection reports heap figures from
+// both before and after, along with the difference between them.
+func TestHandleForceGC(t *testing.T) {
+	server := newServer()
+
+	// Make some garbage for the collector to find.
+	for i := 0; i < 100; i++ {
+		_ = make([]byte, 64<<10)
+	}
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("POST", "/admin/gc", nil))
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	var report gcReport
+	if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+		t.Fatalf("could not decode response body: %v", err)
+	}
+	if report.Before.HeapAlloc == 0 || report.After.HeapAlloc == 0 {
+		t.Errorf("heap figures are missing: %+v", report)
+	}
+	if want := int64(report.Before.HeapAlloc) - int64(report.After.HeapAlloc); report.Freed != want {
+		t.Errorf("freed = %d, want before-after = %d", report.Freed, want)
+	}
+}
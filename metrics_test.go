@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestHandleFlushMetrics makes some changes, flushes, and checks the counts,
+// then checks the next flush starts again from zero.
+func TestHandleFlushMetrics(t *testing.T) {
+	server := newServer()
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rr
+	}
+	flush := func() metricsFlush {
+		t.Helper()
+		rr := send("POST", "/admin/metrics/flush", "")
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		var got metricsFlush
+		if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+			t.Fatalf("could not decode response body: %v", err)
+		}
+		return got
+	}
+
+	send("POST", "/items", `{"id":1,"name":"Alice","age":30}`)
+	send("POST", "/items", `{"id":2,"name":"Bob","age":40}`)
+	send("PUT", "/items/1", `{"name":"Alice","age":31}`)
+	send("DELETE", "/items/2", "")
+	send("GET", "/items/404", "")
+
+	first := flush()
+	want := map[string]int64{
+		"requests": 5, "responses_2xx": 4, "responses_4xx": 1,
+		"items_created": 2, "items_updated": 1, "items_deleted": 1,
+	}
+	for name, n := range want {
+		if got := first.Counters[name]; got != n {
+			t.Errorf("first flush: %s = %d, want %d", name, got, n)
+		}
+	}
+
+	// The first flush request itself was counted once it finished, so it's
+	// the only thing in the second interval.
+	second := flush()
+	for _, name := range counterNames {
+		want := int64(0)
+		if name == "requests" || name == "responses_2xx" {
+			want = 1
+		}
+		if got := second.Counters[name]; got != want {
+			t.Errorf("second flush: %s = %d, want %d", name, got, want)
+		}
+	}
+	if !second.Since.Equal(first.Until) {
+		t.Errorf("second interval starts at %v, want the end of the first, %v", second.Since, first.Until)
+	}
+}
+
+// TestCountersFlushConcurrent flushes while other goroutines count, and checks
+// that the flushes add up to exactly what was counted.
+func TestCountersFlushConcurrent(t *testing.T) {
+	c := newCounters(time.Now())
+
+	const workers, perWorker = 8, 1000
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				c.add("items_created", 1)
+			}
+		}()
+	}
+
+	var total int64
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for flushing := true; flushing; {
+		select {
+		case <-done:
+			flushing = false
+		default:
+		}
+		total += c.flush(time.Now()).Counters["items_created"]
+	}
+
+	if total != workers*perWorker {
+		t.Errorf("flushes added up to %d, want %d", total, workers*perWorker)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// requireSupportedProto is a middleware that rejects requests made with an HTTP
+// version we don't speak (anything older than HTTP/1.0, or newer than HTTP/2)
+// with a 505 HTTP Version Not Supported, instead of letting them fall through
+// to handlers that were never written with them in mind.
+func (s *server) requireSupportedProto(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !r.ProtoAtLeast(1, 0) || r.ProtoMajor > 2 {
+			s.reqLogf(r, "Rejected %s %s from %s: unsupported protocol %q", r.Method, r.URL.Path, r.RemoteAddr, r.Proto)
+			writeJSONError(w, http.StatusHTTPVersionNotSupported, "HTTP version not supported")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// http10Compat is a middleware for HTTP/1.0 clients. Those clients don't
+// understand chunked transfer encoding, so for large responses Go can only
+// signal the end of the body by closing the connection. To give them a proper
+// Content-Length instead, we buffer the whole response and send it in one go.
+func http10Compat(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// HTTP/1.1 and newer clients are fine as they are.
+		if r.ProtoAtLeast(1, 1) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		bw := &bufferedResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(bw, r)
+
+		w.Header().Set("Content-Length", strconv.Itoa(bw.body.Len()))
+		w.WriteHeader(bw.status)
+		w.Write(bw.body.Bytes())
+	})
+}
+
+// bufferedResponseWriter holds on to the status code and body written by a
+// handler instead of sending them straight away. Headers still go to the
+// underlying ResponseWriter, since nothing is sent until WriteHeader is called on it.
+type bufferedResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (bw *bufferedResponseWriter) WriteHeader(status int) {
+	if bw.wroteHeader {
+		return
+	}
+	bw.status = status
+	bw.wroteHeader = true
+}
+
+func (bw *bufferedResponseWriter) Write(b []byte) (int, error) {
+	bw.wroteHeader = true
+	return bw.body.Write(b)
+}
+
+// loggingMiddleware is a middleware that writes one access log line for every
+// request: its method, path, the status code it got and how long it took. The
+// request is counted in the metrics here too, since this is where its status
+// is known.
+func (s *server) loggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rw, r)
+
+		elapsed := time.Since(start)
+		s.metrics.countResponse(rw.status)
+		s.logRequest(r.Context(), r.Method, r.URL.Path, rw.status, elapsed,
+			fmt.Sprintf("%s %s %d in %v", r.Method, r.URL.Path, rw.status, elapsed))
+	})
+}
+
+// recoverMiddleware is a middleware that turns a panicking handler into a
+// 500 Internal Server Error, with the stack trace going to the log instead of
+// taking the connection down with it. It sits inside loggingMiddleware, so
+// the access log records the 500.
+func (s *server) recoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			// http.ErrAbortHandler is how a handler deliberately drops the
+			// connection, so let net/http carry on with that.
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			s.reqLogf(r, "ERROR panic serving %s %s: %v\n%s", r.Method, r.URL.Path, err, debug.Stack())
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "internal server error"})
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// responseWriter remembers the status code a handler sent, for the access
+// log. A handler that never calls WriteHeader gets a 200, so that's the
+// starting value.
+type responseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (rw *responseWriter) WriteHeader(status int) {
+	if !rw.wroteHeader {
+		rw.status = status
+		rw.wroteHeader = true
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	rw.wroteHeader = true
+	return rw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, so
+// streaming handlers can still flush through the wrapper.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// logCutOff is a middleware that logs requests whose context ended before the
+// handler returned, and why. A canceled context means the client went away,
+// while an exceeded deadline means one of our own timeouts fired. They point
+// at very different problems, so we keep them apart in the log.
+func (s *server) logCutOff(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+
+		err := r.Context().Err()
+		if err == nil {
+			return
+		}
+		// The route pattern is only known once chi has routed the request.
+		pattern := chi.RouteContext(r.Context()).RoutePattern()
+		elapsed := time.Since(start)
+		switch {
+		case errors.Is(err, context.Canceled):
+			s.logRequest(r.Context(), r.Method, pattern, 0, elapsed, fmt.Sprintf("Client disconnected: %s %s after %v", r.Method, pattern, elapsed))
+		case errors.Is(err, context.DeadlineExceeded):
+			s.logRequest(r.Context(), r.Method, pattern, 0, elapsed, fmt.Sprintf("Server timeout: %s %s after %v", r.Method, pattern, elapsed))
+		}
+	})
+}
+
+// rejectTrace is a middleware that refuses every TRACE request with a 405
+// Method Not Allowed, whatever the route. TRACE echoes the request back,
+// headers and all, which is the basis of Cross-Site Tracing attacks.
+func (s *server) rejectTrace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodTrace {
+			next.ServeHTTP(w, r)
+			return
+		}
+		s.reqLogf(r, "Rejected TRACE %s from %s", r.URL.Path, r.RemoteAddr)
+		w.Header().Set("Allow", strings.Join(s.allowedMethods(r.URL.Path), ", "))
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	})
+}
+
+// allowedMethods lists the methods the router has a route for at path.
+func (s *server) allowedMethods(path string) []string {
+	var allowed []string
+	for _, method := range []string{
+		http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions,
+	} {
+		if s.router.Match(chi.NewRouteContext(), method, path) {
+			allowed = append(allowed, method)
+		}
+	}
+	return allowed
+}
+
+// rejectSuspiciousPaths is a middleware that turns away requests whose decoded
+// path has ".." segments, null bytes or other control characters with a 400.
+// None of our routes can use them, and they're the staple of path-traversal
+// probes and automated scanners, so we log the attempt and go no further.
+func (s *server) rejectSuspiciousPaths(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if reason := suspiciousPath(r.URL.Path); reason != "" {
+			s.reqLogf(r, "Rejected suspicious path %q from %s: %s", r.URL.Path, r.RemoteAddr, reason)
+			writeJSONError(w, http.StatusBadRequest, "Bad request: invalid path")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// suspiciousPath returns why path looks like an attack, or "" if it looks fine.
+func suspiciousPath(path string) string {
+	for _, c := range path {
+		if c == 0 {
+			return "null byte"
+		}
+		if c < 0x20 || c == 0x7f {
+			return "control character"
+		}
+	}
+	for _, segment := range strings.Split(path, "/") {
+		if segment == ".." {
+			return "parent directory segment"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRequireSupportedProto checks that a request made with an HTTP version the
+// server doesn't support is answered with 505 before reaching any handler.
+func TestRequireSupportedProto(t *testing.T) {
+	server := newServer()
+
+	// httptest builds HTTP/1.1 requests, so we downgrade this one by hand.
+	req := httptest.NewRequest("GET", "/items/1", nil)
+	req.Proto, req.ProtoMajor, req.ProtoMinor = "HTTP/0.9", 0, 9
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusHTTPVersionNotSupported {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusHTTPVersionNotSupported)
+	}
+}
+
+// TestHTTP10Compat sends a raw HTTP/1.0 request over a real connection and checks
+// the response carries an explicit Content-Length and no chunked encoding.
+func TestHTTP10Compat(t *testing.T) {
+	server := newServer()
+	// A long name pushes the body past the size Go would measure on its own.
+	want := Item{ID: 1, Name: strings.Repeat("a", 10000), Age: 30}
+	server.putItem(want)
+
+	ts := httptest.NewServer(server.router)
+	defer ts.Close()
+
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("could not connect: %v", err)
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "GET /items/1 HTTP/1.0\r\n\r\n")
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("could not read response: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("got status %v want %v", resp.StatusCode, http.StatusOK)
+	}
+	if len(resp.TransferEncoding) != 0 {
+		t.Errorf("HTTP/1.0 response used transfer encoding %v", resp.TransferEncoding)
+	}
+	if resp.Header.Get("Content-Length") == "" {
+		t.Error("HTTP/1.0 response has no Content-Length")
+	}
+
+	var got Item
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatalf("could not decode response body: %v", err)
+	}
+	if got != want {
+		t.Errorf("got item with name length %d, want %d", len(got.Name), len(want.Name))
+	}
+}
+
+// TestLogCutOff checks that a request cut off by the client and one cut off by a
+// server deadline are logged differently, along with the route.
+func TestLogCutOff(t *testing.T) {
+	tests := []struct {
+		name string
+		ctx  func() (context.Context, context.CancelFunc)
+		want string
+	}{
+		{"client", func() (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel() // The client hung up.
+			return ctx, cancel
+		}, "Client disconnected: GET /items/{id}"},
+		{"server", func() (context.Context, context.CancelFunc) {
+			// A deadline in the past has already been exceeded.
+			return context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+		}, "Server timeout: GET /items/{id}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newServer()
+			var logs bytes.Buffer
+			server.logger = log.New(&logs, "", 0)
+
+			ctx, cancel := tt.ctx()
+			defer cancel()
+
+			req := httptest.NewRequest("GET", "/items/1", nil).WithContext(ctx)
+			server.router.ServeHTTP(httptest.NewRecorder(), req)
+
+			if !strings.Contains(logs.String(), tt.want) {
+				t.Errorf("log does not contain %q:\n%s", tt.want, logs.String())
+			}
+		})
+	}
+}
+
+// TestRejectTrace checks that TRACE gets a 405 and an Allow header that lists
+// the route's real methods but not TRACE.
+func TestRejectTrace(t *testing.T) {
+	server := newServer()
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("TRACE", "/items/1", nil))
+
+	if status := rr.Code; status != http.StatusMethodNotAllowed {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusMethodNotAllowed)
+	}
+	allow := rr.Header().Get("Allow")
+	if strings.Contains(allow, "TRACE") {
+		t.Errorf("Allow header includes TRACE: %q", allow)
+	}
+	if !strings.Contains(allow, "GET") || !strings.Contains(allow, "PUT") {
+		t.Errorf("Allow header is missing the route's methods: %q", allow)
+	}
+}
+
+// TestRejectSuspiciousPaths checks that traversal and null-byte paths are
+// rejected with a 400 while ordinary paths pass through.
+func TestRejectSuspiciousPaths(t *testing.T) {
+	server := newServer()
+
+	tests := []struct {
+		target string
+		want   int
+	}{
+		{"/items/..%2f..%2fetc%2fpasswd", http.StatusBadRequest},
+		{"/items/../../etc/passwd", http.StatusBadRequest},
+		{"/items/1%00", http.StatusBadRequest},
+		{"/items", http.StatusOK},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, httptest.NewRequest("GET", tt.target, nil))
+		if rr.Code != tt.want {
+			t.Errorf("%s: got status %v want %v", tt.target, rr.Code, tt.want)
+		}
+	}
+}
+
+// TestLoggingMiddleware checks that every request gets an access log line
+// with its method, path and the status it was answered with.
+func TestLoggingMiddleware(t *testing.T) {
+	server := newServer()
+	var logs bytes.Buffer
+	server.jsonLog = newJSONLogger(&logs, &server.logLevel)
+
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	var entry struct {
+		Method     string   `json:"method"`
+		Path       string   `json:"path"`
+		Status     int      `json:"status"`
+		DurationMS *float64 `json:"duration_ms"`
+	}
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatalf("expected a single JSON access log line, got %q: %v", logs.String(), err)
+	}
+	if entry.Method != "GET" || entry.Path != "/healthz" {
+		t.Errorf("wrong request in access log: got %s %s want GET /healthz", entry.Method, entry.Path)
+	}
+	if entry.Status != http.StatusOK {
+		t.Errorf("access log captured wrong status: got %v want %v", entry.Status, http.StatusOK)
+	}
+	if entry.DurationMS == nil {
+		t.Error("access log is missing duration_ms")
+	}
+}
+
+// TestLoggingMiddlewareCapturesErrorStatus checks that a status set by the
+// handler, rather than the default 200, ends up in the access log.
+func TestLoggingMiddlewareCapturesErrorStatus(t *testing.T) {
+	server := newServer()
+	var logs bytes.Buffer
+	server.logger = log.New(&logs, "", 0)
+
+	req, _ := http.NewRequest("GET", "/items/999999", nil)
+	server.router.ServeHTTP(httptest.NewRecorder(), req)
+
+	if !strings.Contains(logs.String(), "GET /items/999999 404 in ") {
+		t.Errorf("access log is missing the 404: %q", logs.String())
+	}
+}
+
+// TestRecoverMiddleware checks that a panicking handler gets the client a 500
+// with a JSON error, rather than a dropped connection.
+func TestRecoverMiddleware(t *testing.T) {
+	server := newServer()
+	var logs bytes.Buffer
+	server.logger = log.New(&logs, "", 0)
+	// A route that exists only to panic; the middleware already wraps it.
+	server.router.Get("/test/panic", func(w http.ResponseWriter, r *http.Request) {
+		var m map[string]int
+		m["boom"]++ // Assigning to a nil map panics.
+	})
+	ts := httptest.NewServer(server.router)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/test/panic")
+	if err != nil {
+		t.Fatalf("request failed instead of getting a response: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if status := resp.StatusCode; status != http.StatusInternalServerError {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusInternalServerError)
+	}
+	var body map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("could not decode response body: %v", err)
+	}
+	if body["error"] != "internal server error" {
+		t.Errorf("handler returned unexpected body: got %v", body)
+	}
+	if !strings.Contains(logs.String(), "ERROR panic serving GET /test/panic") || !strings.Contains(logs.String(), "goroutine") {
+		t.Errorf("expected the panic and its stack in the log, got %q", logs.String())
+	}
+	if !strings.Contains(logs.String(), "GET /test/panic 500 in ") {
+		t.Errorf("expected the 500 in the access log, got %q", logs.String())
+	}
+}
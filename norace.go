@@ -0,0 +1,6 @@
+//go:build !race
+
+package main
+
+// raceEnabled reports whether the binary was built with -race; see race.go.
+const raceEnabled = false
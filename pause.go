@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// pauseRetryAfter is how long paused clients are told to wait before retrying.
+const pauseRetryAfter = 30 * time.Second
+
+// pauseGate is a middleware that answers every non-admin request with a 503
+// Service Unavailable while the server is paused. The listener stays up, so
+// clients are told to retry later instead of having their connections refused,
+// and the admin endpoints keep working so the server can be resumed. So does
+// /healthz: a paused server is still alive, and mustn't look dead to whatever
+// is watching it.
+func (s *server) pauseGate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.paused.Load() && !strings.HasPrefix(r.URL.Path, "/admin/") && r.URL.Path != "/healthz" {
+			w.Header().Set("Retry-After", s.retryAfter(pauseRetryAfter))
+			writeJSONError(w, http.StatusServiceUnavailable, "Service unavailable: paused for maintenance")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleSetPaused returns a handler that pauses or resumes request processing
+// (POST /admin/pause and POST /admin/resume) and reports the new state.
+func (s *server) handleSetPaused(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.paused.Swap(paused) != paused {
+			if paused {
+				s.reqLogf(r, "Request processing paused")
+			} else {
+				s.reqLogf(r, "Request processing resumed")
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"paused": paused})
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestPauseResume checks that requests get a 503 with Retry-After while the
+// server is paused, except for health checks, and go through again after it's
+// resumed.
+func TestPauseResume(t *testing.T) {
+	server := newServer()
+	server.putItem(Item{ID: 1, Name: "Alice", Age: 30})
+
+	do := func(method, url string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, httptest.NewRequest(method, url, nil))
+		return rr
+	}
+
+	if rr := do("POST", "/admin/pause"); rr.Code != http.StatusOK {
+		t.Fatalf("pause: got %v want %v", rr.Code, http.StatusOK)
+	}
+	rr := do("GET", "/items/1")
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("while paused: got %v want %v", rr.Code, http.StatusServiceUnavailable)
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("while paused: no Retry-After header")
+	}
+	if rr := do("GET", "/healthz"); rr.Code != http.StatusOK {
+		t.Errorf("health check while paused: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	// The admin endpoints must keep working, or we could never resume.
+	if rr := do("POST", "/admin/resume"); rr.Code != http.StatusOK {
+		t.Fatalf("resume: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if rr := do("GET", "/items/1"); rr.Code != http.StatusOK {
+		t.Errorf("after resume: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// dataFileFromEnv returns where the datastore is persisted: the DATA_FILE
+// environment variable, or data.json in the working directory.
+func dataFileFromEnv() string {
+	if path := os.Getenv("DATA_FILE"); path != "" {
+		return path
+	}
+	return "data.json"
+}
+
+// flushIntervalFromEnv reads how often changes are written to the data file
+// from FLUSH_INTERVAL, a Go duration like "2s". Unset means 0: the file is only
+// written on shutdown. The second result says what was wrong with the value, if anything.
+func flushIntervalFromEnv() (time.Duration, error) {
+	v := os.Getenv("FLUSH_INTERVAL")
+	if v == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("FLUSH_INTERVAL must not be negative, got %s", v)
+	}
+	return d, nil
+}
+
+// markDirty records that the datastore has changed since it was last flushed.
+func (s *server) markDirty() {
+	s.dirty.Store(true)
+}
+
+// flushLoop writes the datastore to disk through s.flush at most once every
+// interval, and only if something changed in the meantime, so a burst of
+// writes costs one file write rather than one per request. The in-memory
+// store stays the source of truth; the file just catches up. It returns once
+// ctx is done, leaving the final save to the shutdown code.
+func (s *server) flushLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !s.dirty.Swap(false) {
+				continue
+			}
+			if err := s.flush(); err != nil {
+				// Try again on the next tick rather than losing the changes.
+				s.dirty.Store(true)
+				s.logf("ERROR flushing data to %s: %v", s.dataFile, err)
+			}
+		}
+	}
+}
+
+// syncWritesFromEnv reports whether a create should only succeed once it's
+// saved to the data file. It's off unless SYNC_WRITES is set to something
+// true, like "1" or "true".
+func syncWritesFromEnv() bool {
+	on, _ := strconv.ParseBool(os.Getenv("SYNC_WRITES"))
+	return on
+}
+
+// saveToFile writes every item to path as a JSON array. The read lock is only
+// held while encoding, but s.saveMu stays held until the write is done, so a
+// synchronous save that changes the datastore after us also writes after us.
+func (s *server) saveToFile(path string) error {
+	s.mu.RLock()
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	data, err := s.encodeItems()
+	s.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	return s.writeFile(path, data)
+}
+
+// saveLocked writes every item to the data file while the caller holds s.mu,
+// so what's saved is exactly what the caller just changed. Nothing is then
+// left unsaved, so the datastore is no longer dirty. s.mu must be held.
+func (s *server) saveLocked() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	data, err := s.encodeItems()
+	if err != nil {
+		return err
+	}
+	if err := s.writeFile(s.dataFile, data); err != nil {
+		return err
+	}
+	s.dirty.Store(false)
+	return nil
+}
+
+// encodeItems returns every item, ordered by ID, as the JSON array the data
+// file holds. s.mu must be held for reading at least.
+func (s *server) encodeItems() ([]byte, error) {
+	return json.MarshalIndent(s.sortedItems(), "", "  ")
+}
+
+// writeFileAtomic is the default writeFile. The data goes to a temporary file
+// first, which is then renamed over path, so a crash halfway through never
+// leaves a truncated file behind.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	// Removing the temporary file fails harmlessly once it's been renamed.
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// writeSaveError tells the client their change couldn't be saved: 507
+// Insufficient Storage if the disk is full, which they may want to retry
+// later, or 500 Internal Server Error for anything else.
+func (s *server) writeSaveError(w http.ResponseWriter, r *http.Request, err error) {
+	s.reqLogf(r, "ERROR saving data to %s: %v", s.dataFile, err)
+	if errors.Is(err, syscall.ENOSPC) {
+		writeJSONError(w, http.StatusInsufficientStorage, "Insufficient storage: the change could not be saved")
+		return
+	}
+	writeJSONError(w, http.StatusInternalServerError, "Internal server error")
+}
+
+// loadFromFile reads a JSON array of items written by saveToFile and adds
+// them to the datastore. If path doesn't exist, the returned error satisfies
+// errors.Is(err, fs.ErrNotExist).
+func (s *server) loadFromFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var items []Item
+	if err := json.Unmarshal(data, &items); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, item := range items {
+		if err := s.putItem(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
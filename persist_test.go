@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestMain points DATA_FILE at a file that doesn't exist before running the
+// tests, so newServer never loads a data.json left lying around by a real run.
+// It also clears the secrets and auth policy, so a developer's shell with, say,
+// API_KEY exported can't turn auth on under tests that expect it off.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "http-server-test")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("DATA_FILE", filepath.Join(dir, "data.json"))
+	for _, name := range []string{"API_KEY", "JWT_SECRET", "ADMIN_TOKEN", "AUTH_POLICY"} {
+		os.Unsetenv(name)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// TestSaveAndLoadFile saves a populated datastore, loads the file into a fresh
+// server, and checks every item comes back unchanged.
+func TestSaveAndLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+
+	server := newServer()
+	want := []Item{
+		{ID: 1, Name: "Alice", Age: 30, CreatedAt: server.now(), UpdatedAt: server.now()},
+		{ID: 2, Name: "Bob", Age: 40, CreatedAt: server.now(), UpdatedAt: server.now()},
+	}
+	for _, item := range want {
+		server.putItem(item)
+	}
+	if err := server.saveToFile(path); err != nil {
+		t.Fatalf("could not save: %v", err)
+	}
+
+	fresh := newServer()
+	if err := fresh.loadFromFile(path); err != nil {
+		t.Fatalf("could not load: %v", err)
+	}
+	if len(fresh.store.List()) != len(want) {
+		t.Fatalf("loaded %d items, want %d", len(fresh.store.List()), len(want))
+	}
+	for _, item := range want {
+		if got := storedItem(fresh, item.ID); got != item {
+			t.Errorf("item %d: got %+v want %+v", item.ID, got, item)
+		}
+	}
+}
+
+// TestNewServerLoadsDataFile checks that newServer picks up the file named by
+// DATA_FILE, and starts empty when there's no such file.
+func TestNewServerLoadsDataFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	t.Setenv("DATA_FILE", path)
+
+	if server := newServer(); len(server.store.List()) != 0 {
+		t.Fatalf("started with %d items from a missing file", len(server.store.List()))
+	}
+
+	os.WriteFile(path, []byte(`[{"id":7,"name":"Grace","age":85}]`), 0o644)
+	server := newServer()
+	if got := storedItem(server, 7); got.Name != "Grace" {
+		t.Errorf("item 7 was not loaded, got %+v", got)
+	}
+}
+
+// TestFlushLoopCoalescesWrites fires a burst of creates at a server flushing
+// every 50ms, and checks the file was written far fewer times than there were
+// writes, yet still ends up holding every item.
+func TestFlushLoopCoalescesWrites(t *testing.T) {
+	server := newServer()
+	server.dataFile = filepath.Join(t.TempDir(), "data.json")
+	var flushes atomic.Int64
+	server.flush = func() error {
+		flushes.Add(1)
+		return server.saveToFile(server.dataFile)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server.flushLoop(ctx, 50*time.Millisecond)
+	}()
+
+	const writes = 500
+	for i := 0; i < writes; i++ {
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, httptest.NewRequest("POST", "/items", strings.NewReader(`{"name":"Burst","age":1}`)))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("create %d: got %v want %v", i, rr.Code, http.StatusCreated)
+		}
+	}
+
+	// Wait for the flush that picks up the last of the writes.
+	deadline := time.Now().Add(2 * time.Second)
+	for server.dirty.Load() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if n := flushes.Load(); n == 0 || n > writes/10 {
+		t.Errorf("file written %d times for %d writes, want at least 1 and at most %d", n, writes, writes/10)
+	}
+	data, err := os.ReadFile(server.dataFile)
+	if err != nil {
+		t.Fatalf("could not read data file: %v", err)
+	}
+	var saved []Item
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatalf("could not decode data file: %v", err)
+	}
+	if len(saved) != writes {
+		t.Errorf("data file holds %d items, want %d", len(saved), writes)
+	}
+}
+
+// TestFlushLoopSkipsWhenClean checks nothing is written while the datastore
+// hasn't changed.
+func TestFlushLoopSkipsWhenClean(t *testing.T) {
+	server := newServer()
+	var flushes atomic.Int64
+	server.flush = func() error {
+		flushes.Add(1)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	server.flushLoop(ctx, 5*time.Millisecond)
+
+	if n := flushes.Load(); n != 0 {
+		t.Errorf("flushed %d times with no changes, want 0", n)
+	}
+}
+
+// TestFlushIntervalFromEnv checks FLUSH_INTERVAL is read as a duration, with
+// unset and unusable values meaning no periodic flushing.
+func TestFlushIntervalFromEnv(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"2s", 2 * time.Second, false},
+		{"0", 0, false},
+		{"-1s", 0, true},
+		{"soon", 0, true},
+	}
+	for _, tt := range tests {
+		t.Setenv("FLUSH_INTERVAL", tt.value)
+		got, err := flushIntervalFromEnv()
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("FLUSH_INTERVAL=%q: got %v, %v want %v (error: %v)", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// TestHandleCreateItemSyncWrites checks that with SYNC_WRITES on, a create is
+// saved before it's acknowledged, and that one the disk has no room for is
+// rolled back and answered with a 507.
+func TestHandleCreateItemSyncWrites(t *testing.T) {
+	server := newServer()
+	server.syncWrites = true
+	server.putItem(Item{ID: 1, Name: "Alice", Age: 30})
+
+	var saved []Item
+	server.writeFile = func(path string, data []byte) error {
+		return json.Unmarshal(data, &saved)
+	}
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("POST", "/items", strings.NewReader(`{"id":2,"name":"Bob","age":40}`)))
+	if status := rr.Code; status != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+	}
+	if len(saved) != 2 || saved[1].Name != "Bob" {
+		t.Errorf("saved %+v, want Alice and Bob", saved)
+	}
+	if server.dirty.Load() {
+		t.Errorf("datastore still dirty after a synchronous save")
+	}
+
+	// A full disk fails the save, and Carol must not be left behind in memory.
+	server.writeFile = func(path string, data []byte) error {
+		return &os.PathError{Op: "write", Path: path, Err: syscall.ENOSPC}
+	}
+	rr = httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("POST", "/items", strings.NewReader(`{"id":3,"name":"Carol","age":50}`)))
+	if status := rr.Code; status != http.StatusInsufficientStorage {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusInsufficientStorage)
+	}
+	if _, found := server.store.Get(3); found {
+		t.Errorf("item 3 still stored after its save failed")
+	}
+	// The rollback leaves no trace: no create or delete counted, and nothing
+	// new to flush.
+	if counts := server.metrics.flush(server.now()).Counters; counts["items_created"] != 2 || counts["items_deleted"] != 0 {
+		t.Errorf("after a rollback, got counters %v; want only Alice's and Bob's creates", counts)
+	}
+	if server.dirty.Load() {
+		t.Errorf("datastore dirty after a rollback")
+	}
+	rr = httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("GET", "/items/3", nil))
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("GET after rollback: handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
+	}
+
+	// Any other failure rolls back as well, but it's the server's fault.
+	server.writeFile = func(path string, data []byte) error {
+		return &os.PathError{Op: "rename", Path: path, Err: syscall.EACCES}
+	}
+	rr = httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("POST", "/items", strings.NewReader(`{"id":3,"name":"Carol","age":50}`)))
+	if status := rr.Code; status != http.StatusInternalServerError {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusInternalServerError)
+	}
+	if got := len(server.store.List()); got != 2 {
+		t.Errorf("got %d items after failed creates, want 2", got)
+	}
+}
+
+// TestSyncedCreateNotOverwrittenByFlush holds a background flush up mid-write
+// while a synchronous create comes in, and checks the create's newer copy is
+// the one left on disk once both are done.
+func TestSyncedCreateNotOverwrittenByFlush(t *testing.T) {
+	server := newServer()
+	server.syncWrites = true
+	server.putItem(Item{ID: 1, Name: "Alice", Age: 30})
+
+	var mu sync.Mutex
+	var last []Item // What the most recent write put on disk.
+	started := make(chan struct{})
+	release := make(chan struct{})
+	first := true
+	server.writeFile = func(path string, data []byte) error {
+		mu.Lock()
+		block := first
+		first = false
+		mu.Unlock()
+		// The first write, the background flush's, waits to be released.
+		if block {
+			close(started)
+			<-release
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return json.Unmarshal(data, &last)
+	}
+
+	flushed := make(chan error)
+	go func() { flushed <- server.flush() }()
+	<-started
+
+	created := make(chan int)
+	go func() {
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, httptest.NewRequest("POST", "/items", strings.NewReader(`{"id":2,"name":"Bob","age":40}`)))
+		created <- rr.Code
+	}()
+	// Give the create its chance to write ahead of the stalled flush.
+	select {
+	case code := <-created:
+		t.Fatalf("create finished (%v) while an older save was still being written", code)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	if err := <-flushed; err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+	if code := <-created; code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", code, http.StatusCreated)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(last) != 2 || last[1].Name != "Bob" {
+		t.Errorf("file holds %+v after both saves, want Alice and Bob", last)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// promMetrics are the metrics GET /metrics exposes for Prometheus to scrape.
+// Each server has its own registry rather than using the global one, so
+// several servers in one process (as in the tests) don't clash.
+type promMetrics struct {
+	registry *prometheus.Registry
+	requests *prometheus.CounterVec   // Finished requests, by method, route and status.
+	latency  *prometheus.HistogramVec // How long they took, by the same labels.
+}
+
+// newPromMetrics registers the request metrics, a gauge reporting how many
+// items s holds, and the usual Go runtime and process metrics.
+func (s *server) newPromMetrics() *promMetrics {
+	labels := []string{"method", "route", "status"}
+	m := &promMetrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP requests served, by method, route and status code.",
+		}, labels),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "How long HTTP requests took to serve, by method, route and status code.",
+			Buckets: prometheus.DefBuckets,
+		}, labels),
+	}
+	m.registry.MustRegister(
+		m.requests,
+		m.latency,
+		// The size is counted by the datastore at scrape time, so it's never
+		// stale, and no scrape has to copy every item to get it.
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "datastore_items",
+			Help: "Items currently in the datastore.",
+		}, func() float64 { return float64(s.store.Len()) }),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// instrument is a middleware that records each request in the Prometheus
+// metrics. Requests are labeled with the route pattern they matched (like
+// /items/{id}) rather than their path, so there's one series per route
+// instead of one per item; anything that matched no route is "unmatched".
+func (s *server) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rw, r)
+
+		// chi fills in the pattern as it routes, so it's only known now.
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := strconv.Itoa(rw.status)
+		s.prom.requests.WithLabelValues(r.Method, route, status).Inc()
+		s.prom.latency.WithLabelValues(r.Method, route, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+// handlePrometheus handles scrapes of the Prometheus metrics (GET /metrics).
+func (s *server) handlePrometheus() http.HandlerFunc {
+	return promhttp.HandlerFor(s.prom.registry, promhttp.HandlerOpts{}).ServeHTTP
+}
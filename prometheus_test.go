@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// TestPrometheusMetrics makes a few requests and checks they're counted under
+// their route pattern and status, and that GET /metrics reports them along
+// with the datastore size.
+func TestPrometheusMetrics(t *testing.T) {
+	server := newServer()
+	server.putItem(Item{ID: 1, Name: "Alice", Age: 30})
+
+	for _, path := range []string{"/items/1", "/items/1", "/items/2", "/nowhere"} {
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+	}
+
+	tests := []struct {
+		route, status string
+		want          float64
+	}{
+		{"/items/{id}", "200", 2},
+		{"/items/{id}", "404", 1},
+		{"unmatched", "404", 1},
+	}
+	for _, tt := range tests {
+		counter := server.prom.requests.WithLabelValues("GET", tt.route, tt.status)
+		if got := testutil.ToFloat64(counter); got != tt.want {
+			t.Errorf("GET %s %s: counted %v requests, want %v", tt.route, tt.status, got, tt.want)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	for _, want := range []string{
+		`http_requests_total{method="GET",route="/items/{id}",status="200"} 2`,
+		`http_request_duration_seconds_count{method="GET",route="/items/{id}",status="200"} 2`,
+		"datastore_items 1",
+	} {
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("metrics are missing %q", want)
+		}
+	}
+}
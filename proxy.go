@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// externalPath turns a path on this server (like "/items/101") into the path
+// a client has to use to reach it. Behind a proxy that strips a prefix before
+// forwarding (say, /api), the client-facing path is /api/items/101, and the
+// proxy tells us the prefix in X-Forwarded-Prefix.
+//
+// The header is only honored with -trust-proxy: anyone can send it, so
+// without a proxy in front that sets it, believing it would let clients
+// point our Location headers wherever they like.
+func (s *server) externalPath(r *http.Request, path string) string {
+	if !s.trustProxy {
+		return path
+	}
+	prefix := strings.TrimRight(r.Header.Get("X-Forwarded-Prefix"), "/")
+	// Only accept a plain absolute path. Something like "//evil.example"
+	// would turn the result into a link to another host.
+	if !strings.HasPrefix(prefix, "/") || strings.HasPrefix(prefix, "//") || strings.ContainsAny(prefix, "?#\\") {
+		return path
+	}
+	return prefix + path
+}
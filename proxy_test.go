@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestCreateLocationWithForwardedPrefix checks that the Location header of a
+// new item includes the prefix the proxy stripped, once the proxy is trusted.
+func TestCreateLocationWithForwardedPrefix(t *testing.T) {
+	server := newServer()
+	server.trustProxy = true
+
+	req := httptest.NewRequest("POST", "/items", strings.NewReader(`{"id":42,"name":"Proxied","age":1}`))
+	req.Header.Set("X-Forwarded-Prefix", "/api/")
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+	}
+	if got := rr.Header().Get("Location"); got != "/api/items/42" {
+		t.Errorf("wrong Location: got %q want /api/items/42", got)
+	}
+}
+
+// TestExternalPath checks when X-Forwarded-Prefix is and isn't believed.
+func TestExternalPath(t *testing.T) {
+	tests := []struct {
+		trust  bool
+		prefix string
+		want   string
+	}{
+		{trust: true, prefix: "", want: "/items/1"},
+		{trust: true, prefix: "/api", want: "/api/items/1"},
+		{trust: true, prefix: "/api/v1/", want: "/api/v1/items/1"},
+		{trust: false, prefix: "/api", want: "/items/1"},
+		{trust: true, prefix: "api", want: "/items/1"},
+		{trust: true, prefix: "//evil.example", want: "/items/1"},
+		{trust: true, prefix: "/api?x=1", want: "/items/1"},
+	}
+	for _, tt := range tests {
+		server := &server{trustProxy: tt.trust}
+		req := httptest.NewRequest("GET", "/items/1", nil)
+		req.Header.Set("X-Forwarded-Prefix", tt.prefix)
+		if got := server.externalPath(req, "/items/1"); got != tt.want {
+			t.Errorf("trust=%v prefix=%q: got %q want %q", tt.trust, tt.prefix, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// defaultQueueTimeout is how long a queued request waits for a slot when
+// REQUEST_QUEUE_TIMEOUT isn't set.
+const defaultQueueTimeout = time.Second
+
+// queueRetryAfter is how long clients turned away by the limiter are told to
+// wait before retrying.
+const queueRetryAfter = time.Second
+
+// requestLimiter caps how many requests are served at once. Requests beyond
+// the cap wait in a queue of bounded depth for a slot to free up, rather than
+// being turned away straight away, which smooths over short bursts. Only a
+// full queue, or a wait that runs out, gets a 503.
+type requestLimiter struct {
+	slots    chan struct{} // One token per request being served.
+	maxQueue int64         // How many requests may wait for a slot; 0 means none.
+	queued   atomic.Int64  // How many requests are waiting right now.
+	wait     time.Duration // How long a request may wait before giving up.
+}
+
+// newRequestLimiter returns a limiter serving up to concurrency requests at
+// once, with up to depth more waiting for at most wait each.
+func newRequestLimiter(concurrency, depth int, wait time.Duration) *requestLimiter {
+	return &requestLimiter{
+		slots:    make(chan struct{}, concurrency),
+		maxQueue: int64(depth),
+		wait:     wait,
+	}
+}
+
+// requestLimiterFromEnv builds the limiter from MAX_CONCURRENT_REQUESTS,
+// REQUEST_QUEUE_DEPTH and REQUEST_QUEUE_TIMEOUT (a Go duration like "2s").
+// Without a positive MAX_CONCURRENT_REQUESTS there's no limit, and nil is returned.
+func requestLimiterFromEnv() *requestLimiter {
+	concurrency, err := strconv.Atoi(os.Getenv("MAX_CONCURRENT_REQUESTS"))
+	if err != nil || concurrency <= 0 {
+		return nil
+	}
+	depth, err := strconv.Atoi(os.Getenv("REQUEST_QUEUE_DEPTH"))
+	if err != nil || depth < 0 {
+		depth = 0
+	}
+	wait, err := time.ParseDuration(os.Getenv("REQUEST_QUEUE_TIMEOUT"))
+	if err != nil || wait <= 0 {
+		wait = defaultQueueTimeout
+	}
+	return newRequestLimiter(concurrency, depth, wait)
+}
+
+// limitRequests is a middleware that passes requests through s.limiter, when
+// there is one. Admin requests skip the queue, so the server can still be
+// inspected and paused while it's swamped, and so do health checks, so a busy
+// server isn't mistaken for a dead one.
+func (s *server) limitRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l := s.limiter
+		if l == nil || strings.HasPrefix(r.URL.Path, "/admin/") || r.URL.Path == "/healthz" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Take a free slot if there is one; otherwise join the queue, as long
+		// as it has room.
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			if l.queued.Add(1) > l.maxQueue {
+				l.queued.Add(-1)
+				w.Header().Set("Retry-After", s.retryAfter(queueRetryAfter))
+				writeJSONError(w, http.StatusServiceUnavailable, "Service unavailable: too many requests queued")
+				return
+			}
+			timer := time.NewTimer(l.wait)
+			select {
+			case l.slots <- struct{}{}:
+				timer.Stop()
+				l.queued.Add(-1)
+			case <-timer.C:
+				l.queued.Add(-1)
+				w.Header().Set("Retry-After", s.retryAfter(queueRetryAfter))
+				writeJSONError(w, http.StatusServiceUnavailable, "Service unavailable: timed out waiting in queue")
+				return
+			case <-r.Context().Done():
+				// The client gave up waiting; there's no one to answer.
+				timer.Stop()
+				l.queued.Add(-1)
+				return
+			}
+		}
+		defer func() { <-l.slots }()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newBlockingServer returns a server with the given limiter and a test route
+// that doesn't answer until release is closed.
+func newBlockingServer(l *requestLimiter, release <-chan struct{}) *server {
+	server := newServer()
+	server.limiter = l
+	server.router.Get("/test/block", func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})
+	return server
+}
+
+// serveAsync sends a GET in the background and returns a channel that gets
+// the status code once it's answered.
+func serveAsync(server *server, path string) <-chan int {
+	code := make(chan int, 1)
+	go func() {
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		code <- rr.Code
+	}()
+	return code
+}
+
+// waitFor polls cond until it holds, failing the test after a second.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestLimitRequestsQueues checks that a burst beyond the concurrency cap is
+// queued and served once a slot frees up, and that only requests beyond the
+// queue's depth are turned away.
+func TestLimitRequestsQueues(t *testing.T) {
+	l := newRequestLimiter(1, 1, 5*time.Second)
+	release := make(chan struct{})
+	server := newBlockingServer(l, release)
+
+	first := serveAsync(server, "/test/block")
+	waitFor(t, "the first request to take the slot", func() bool { return len(l.slots) == 1 })
+	second := serveAsync(server, "/test/block")
+	waitFor(t, "the second request to queue", func() bool { return l.queued.Load() == 1 })
+
+	// The slot and the queue are both taken, so a third request is turned away at once.
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("GET", "/test/block", nil))
+	if status := rr.Code; status != http.StatusServiceUnavailable {
+		t.Errorf("third request: got %v want %v", status, http.StatusServiceUnavailable)
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("third request: expected a Retry-After header")
+	}
+
+	// Freeing the slot lets both the first and the queued request finish.
+	close(release)
+	for name, code := range map[string]<-chan int{"first": first, "second": second} {
+		if status := <-code; status != http.StatusOK {
+			t.Errorf("%s request: got %v want %v", name, status, http.StatusOK)
+		}
+	}
+}
+
+// TestLimitRequestsNoQueue checks that without a queue, requests beyond the
+// cap are rejected immediately.
+func TestLimitRequestsNoQueue(t *testing.T) {
+	l := newRequestLimiter(1, 0, 5*time.Second)
+	release := make(chan struct{})
+	server := newBlockingServer(l, release)
+	defer close(release)
+
+	serveAsync(server, "/test/block")
+	waitFor(t, "the first request to take the slot", func() bool { return len(l.slots) == 1 })
+
+	start := time.Now()
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("GET", "/test/block", nil))
+	if status := rr.Code; status != http.StatusServiceUnavailable {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusServiceUnavailable)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("rejection took %v, expected it to be immediate", elapsed)
+	}
+}
+
+// TestLimitRequestsQueueTimeout checks that a queued request gives up with a
+// 503 once it has waited too long.
+func TestLimitRequestsQueueTimeout(t *testing.T) {
+	l := newRequestLimiter(1, 1, 20*time.Millisecond)
+	release := make(chan struct{})
+	server := newBlockingServer(l, release)
+	defer close(release)
+
+	serveAsync(server, "/test/block")
+	waitFor(t, "the first request to take the slot", func() bool { return len(l.slots) == 1 })
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("GET", "/test/block", nil))
+	if status := rr.Code; status != http.StatusServiceUnavailable {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusServiceUnavailable)
+	}
+	if n := l.queued.Load(); n != 0 {
+		t.Errorf("queue still holds %d request(s) after the timeout", n)
+	}
+}
+
+// TestLimitRequestsSkipsAdmin checks that admin requests and health checks
+// aren't held up by a full server.
+func TestLimitRequestsSkipsAdmin(t *testing.T) {
+	l := newRequestLimiter(1, 0, time.Second)
+	release := make(chan struct{})
+	server := newBlockingServer(l, release)
+	defer close(release)
+
+	serveAsync(server, "/test/block")
+	waitFor(t, "the first request to take the slot", func() bool { return len(l.slots) == 1 })
+
+	for _, path := range []string{"/admin/draining", "/healthz"} {
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		if status := rr.Code; status != http.StatusOK {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", path, status, http.StatusOK)
+		}
+	}
+}
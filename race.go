@@ -0,0 +1,7 @@
+//go:build race
+
+package main
+
+// raceEnabled is set when the binary is built with -race. The race detector
+// adds no exported way to ask, so the build tag it sets is the only tell.
+const raceEnabled = true
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The rate limit used when RATE_LIMIT and RATE_LIMIT_BURST aren't set.
+const (
+	defaultRateLimit = 10 // Requests per second, per client IP.
+	defaultRateBurst = 20 // Requests a client may make at once after being idle.
+)
+
+// rateLimiter hands each client IP a token bucket: it holds up to burst
+// tokens, refills at rate tokens per second, and every request takes one. A
+// client that's been quiet can send a burst straight away, but can't keep up
+// more than rate requests a second.
+type rateLimiter struct {
+	rate  float64 // Tokens added per second.
+	burst float64 // The most tokens a bucket holds.
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// tokenBucket is one client's bucket, as of last.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns a limiter allowing rate requests a second per
+// client, in bursts of up to burst.
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: float64(burst), buckets: make(map[string]*tokenBucket)}
+}
+
+// rateLimiterFromEnv builds the limiter from RATE_LIMIT (requests per second,
+// like "10" or "0.5") and RATE_LIMIT_BURST. Values that don't parse fall back
+// to the defaults, and RATE_LIMIT=0 turns the limit off, returning nil.
+func rateLimiterFromEnv() *rateLimiter {
+	rate, err := strconv.ParseFloat(os.Getenv("RATE_LIMIT"), 64)
+	if err != nil || rate < 0 || math.IsInf(rate, 0) || math.IsNaN(rate) {
+		rate = defaultRateLimit
+	}
+	if rate == 0 {
+		return nil
+	}
+	burst, err := strconv.Atoi(os.Getenv("RATE_LIMIT_BURST"))
+	if err != nil || burst < 1 {
+		burst = defaultRateBurst
+	}
+	return newRateLimiter(rate, burst)
+}
+
+// allow takes a token from client's bucket as of now. If the bucket is empty,
+// it reports false along with how long until the next token arrives.
+func (l *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	b, found := l.buckets[client]
+	if !found {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep forgets the buckets that have refilled completely, since a full
+// bucket is no different from a new one. It only looks once per refill
+// period, so the map stays as big as the set of recent clients without every
+// request paying to scan it. l.mu must be held.
+func (l *rateLimiter) sweep(now time.Time) {
+	refill := time.Duration(l.burst / l.rate * float64(time.Second))
+	if now.Sub(l.lastSweep) < refill {
+		return
+	}
+	l.lastSweep = now
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// rateLimit is a middleware that turns away clients making requests faster
+// than s.rateLimiter allows, when there is one, with a 429 Too Many Requests
+// saying when to come back. Admin requests are counted like any other: they're
+// checked before the admin token is, so exempting them would let a client
+// guess tokens as fast as it liked. Health checks are the one exception: they
+// give nothing away, and a monitor polling often shouldn't see the server fail.
+func (s *server) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l := s.rateLimiter
+		if l == nil || r.URL.Path == "/healthz" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		client := s.clientIP(r)
+		if ok, wait := l.allow(client, time.Now()); !ok {
+			s.reqLogf(r, "Rate limited %s", client)
+			// Retry-After is in whole seconds, so round up, and never say 0.
+			w.Header().Set("Retry-After", s.retryAfter(max(time.Duration(math.Ceil(wait.Seconds()))*time.Second, time.Second)))
+			writeJSONError(w, http.StatusTooManyRequests, "Too many requests: slow down")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP works out which address a request came from: the IP in
+// RemoteAddr, or with -trust-proxy, the one the proxy in front of us says it
+// was talking to. That's the last entry in X-Forwarded-For, as the proxy adds
+// it; anything before it was sent by the client, who can put whatever they
+// like there.
+func (s *server) clientIP(r *http.Request) string {
+	if s.trustProxy {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			hops := strings.Split(forwarded[len(forwarded)-1], ",")
+			if ip := strings.TrimSpace(hops[len(hops)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestRateLimit sends requests from one IP faster than the limit allows, and
+// checks the burst gets through, the next request gets a 429 with a
+// Retry-After, admin routes included, and another IP isn't affected, while
+// health checks are never limited.
+func TestRateLimit(t *testing.T) {
+	server := newServer()
+	server.rateLimiter = newRateLimiter(1, 3)
+
+	get := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/schema", nil)
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+		return rr
+	}
+	for i := 0; i < 3; i++ {
+		if rr := get("192.0.2.1:1000"); rr.Code != http.StatusOK {
+			t.Fatalf("request %d: handler returned wrong status code: got %v want %v", i+1, rr.Code, http.StatusOK)
+		}
+	}
+	// A new port is the same client as far as the limit is concerned.
+	rr := get("192.0.2.1:2000")
+	if status := rr.Code; status != http.StatusTooManyRequests {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusTooManyRequests)
+	}
+	if wait, err := strconv.Atoi(rr.Header().Get("Retry-After")); err != nil || wait < 1 {
+		t.Errorf("got Retry-After %q, want a positive number of seconds", rr.Header().Get("Retry-After"))
+	}
+	if rr := get("192.0.2.2:1000"); rr.Code != http.StatusOK {
+		t.Errorf("other client: handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	req := httptest.NewRequest("GET", "/healthz", nil)
+	req.RemoteAddr = "192.0.2.1:1000"
+	rr = httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("health check: handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	// Admin routes are limited too, even before the token is checked.
+	server.adminToken = "admin-token"
+	req = httptest.NewRequest("GET", "/admin/draining", nil)
+	req.RemoteAddr = "192.0.2.1:3000"
+	req.Header.Set("X-Admin-Token", "guess")
+	rr = httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusTooManyRequests {
+		t.Errorf("admin route: handler returned wrong status code: got %v want %v", status, http.StatusTooManyRequests)
+	}
+}
+
+// TestRateLimiterRefills checks an empty bucket gets a token back after
+// 1/rate seconds, and never holds more than burst.
+func TestRateLimiterRefills(t *testing.T) {
+	l := newRateLimiter(2, 2)
+	start := time.Unix(1_000_000, 0)
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow("a", start); !ok {
+			t.Fatalf("request %d refused", i+1)
+		}
+	}
+	ok, wait := l.allow("a", start)
+	if ok || wait != 500*time.Millisecond {
+		t.Errorf("empty bucket: got %v, wait %v; want refused, wait 500ms", ok, wait)
+	}
+	if ok, _ := l.allow("a", start.Add(500*time.Millisecond)); !ok {
+		t.Errorf("refused after waiting for a token")
+	}
+
+	// However long the client was away, they get at most burst at once.
+	later := start.Add(time.Hour)
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.allow("a", later); !ok {
+			t.Fatalf("request %d after a break refused", i+1)
+		}
+	}
+	if ok, _ := l.allow("a", later); ok {
+		t.Errorf("allowed more than the burst after a break")
+	}
+}
+
+// TestClientIP checks X-Forwarded-For is only believed with -trust-proxy, and
+// that it's the proxy's entry, the last one, that counts.
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		trust     bool
+		forwarded string
+		want      string
+	}{
+		{false, "", "192.0.2.1"},
+		{false, "203.0.113.9", "192.0.2.1"},
+		{true, "", "192.0.2.1"},
+		{true, "203.0.113.9", "203.0.113.9"},
+		{true, "10.0.0.1, 203.0.113.9", "203.0.113.9"},
+	}
+	for _, tt := range tests {
+		server := &server{trustProxy: tt.trust}
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		if tt.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		if got := server.clientIP(req); got != tt.want {
+			t.Errorf("trust=%v, X-Forwarded-For %q: got %q want %q", tt.trust, tt.forwarded, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// handleReorderItems handles requests to arrange items in a new order (e.g.,
+// POST /items/reorder with a JSON array of IDs like [3,1,2]). The first ID
+// listed gets position 1, the next position 2, and so on; items left out keep
+// the position they had. Every ID has to exist and appear only once, or
+// nothing is changed. The response lists the reordered items in their new order.
+func (s *server) handleReorderItems() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var ids []int
+		if err := s.decodeJSON(r, &ids); err != nil {
+			s.reqLogf(r, "ERROR decoding request body: %v", err)
+			writeJSONError(w, http.StatusBadRequest, decodeErrorMessage(err))
+			return
+		}
+		if len(ids) == 0 {
+			writeJSONError(w, http.StatusBadRequest, "Bad request: no ids given")
+			return
+		}
+
+		// Checking the IDs and moving the items happen under one write lock,
+		// so readers see either the old order or the new one, never a mix.
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		items, err := s.itemsForReorder(ids)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Bad request: %v", err))
+			return
+		}
+		now := s.now()
+		var moved []Item // The items already updated, as they were before.
+		for i := range items {
+			if items[i].Position == i+1 {
+				continue // Already in place, so there's nothing to update.
+			}
+			old := items[i]
+			items[i].Position = i + 1
+			items[i].UpdatedAt = now
+			items[i].ModifiedBy = principalFrom(r.Context())
+			if err := s.putItem(items[i]); err != nil {
+				// Put the items moved so far back where they were, so a
+				// failed reorder changes nothing, just like a rejected one.
+				s.restoreItems(r, moved)
+				s.writeStoreError(w, r, err)
+				return
+			}
+			moved = append(moved, old)
+		}
+		s.reqLogf(r, "Reordered %d item(s)", len(items))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(items)
+	}
+}
+
+// restoreItems puts items back into the datastore as they are given, undoing a
+// partly applied change. s.mu must be held for writing.
+func (s *server) restoreItems(r *http.Request, items []Item) {
+	for _, item := range items {
+		if err := s.putItem(item); err != nil {
+			s.reqLogf(r, "ERROR restoring item %d: %v", item.ID, err)
+		}
+	}
+}
+
+// itemsForReorder looks up the items with the given IDs, in that order,
+// failing if any ID is listed twice or has no item. s.mu must be held for
+// reading at least.
+func (s *server) itemsForReorder(ids []int) ([]Item, error) {
+	items := make([]Item, 0, len(ids))
+	seen := make(map[int]bool, len(ids))
+	var missing []int
+	for _, id := range ids {
+		if seen[id] {
+			return nil, fmt.Errorf("ID %d listed more than once", id)
+		}
+		seen[id] = true
+		item, found := s.store.Get(id)
+		if !found {
+			missing = append(missing, id)
+			continue
+		}
+		items = append(items, item)
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("no items with IDs %s", joinInts(missing))
+	}
+	return items, nil
+}
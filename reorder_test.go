@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestHandleReorderItems reorders three items and checks the list sorted by
+// position comes back in the new order.
+func TestHandleReorderItems(t *testing.T) {
+	server := newServer()
+	server.putItem(Item{ID: 1, Name: "Write tests", Age: 1})
+	server.putItem(Item{ID: 2, Name: "Fix bug", Age: 1})
+	server.putItem(Item{ID: 3, Name: "Ship it", Age: 1})
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("POST", "/items/reorder", strings.NewReader(`[3, 1, 2]`)))
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	var reordered []Item
+	if err := json.NewDecoder(rr.Body).Decode(&reordered); err != nil {
+		t.Fatalf("could not decode response body: %v", err)
+	}
+	if len(reordered) != 3 || reordered[0].ID != 3 || reordered[0].Position != 1 {
+		t.Errorf("expected item 3 first at position 1, got %+v", reordered)
+	}
+
+	rr = httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("GET", "/items?sort=position", nil))
+	var list itemList
+	if err := json.NewDecoder(rr.Body).Decode(&list); err != nil {
+		t.Fatalf("could not decode response body: %v", err)
+	}
+	var gotIDs []int
+	for _, item := range list.Items {
+		gotIDs = append(gotIDs, item.ID)
+	}
+	if want := []int{3, 1, 2}; !slices.Equal(gotIDs, want) {
+		t.Errorf("listed by position: got IDs %v want %v", gotIDs, want)
+	}
+}
+
+// TestHandleReorderItemsInvalid checks that unknown and repeated IDs are
+// rejected without moving any item.
+func TestHandleReorderItemsInvalid(t *testing.T) {
+	server := newServer()
+	server.putItem(Item{ID: 1, Name: "Alice", Age: 30, Position: 5})
+	server.putItem(Item{ID: 2, Name: "Bob", Age: 40, Position: 6})
+
+	for _, body := range []string{`[2, 1, 99]`, `[2, 2]`, `[]`} {
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, httptest.NewRequest("POST", "/items/reorder", strings.NewReader(body)))
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("%s: handler returned wrong status code: got %v want %v", body, status, http.StatusBadRequest)
+		}
+	}
+	if got := storedItem(server, 1).Position; got != 5 {
+		t.Errorf("item 1 moved to position %d by a rejected reorder, want 5", got)
+	}
+}
+
+// failingStore is a memStore where creating or updating one item always fails.
+type failingStore struct {
+	*memStore
+	failID int
+}
+
+func (f *failingStore) Create(item Item) error {
+	if item.ID == f.failID {
+		return errors.New("disk I/O error")
+	}
+	return f.memStore.Create(item)
+}
+
+func (f *failingStore) Update(id int, item Item) error {
+	if id == f.failID {
+		return errors.New("disk I/O error")
+	}
+	return f.memStore.Update(id, item)
+}
+
+// TestHandleReorderItemsStoreError checks that when the datastore fails
+// partway through a reorder, the items already moved are put back.
+func TestHandleReorderItemsStoreError(t *testing.T) {
+	server := newServer()
+	server.putItem(Item{ID: 1, Name: "Alice", Age: 30, Position: 5})
+	server.putItem(Item{ID: 2, Name: "Bob", Age: 40, Position: 6})
+	server.putItem(Item{ID: 3, Name: "Carol", Age: 50, Position: 7})
+	server.store = &failingStore{memStore: server.store.(*memStore), failID: 3}
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("POST", "/items/reorder", strings.NewReader(`[1, 2, 3]`)))
+	if status := rr.Code; status != http.StatusInternalServerError {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusInternalServerError)
+	}
+	for id, want := range map[int]int{1: 5, 2: 6, 3: 7} {
+		if got := storedItem(server, id).Position; got != want {
+			t.Errorf("item %d left at position %d by a failed reorder, want %d", id, got, want)
+		}
+	}
+}
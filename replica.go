@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// newInstanceID returns an ID for this server process: the host name plus a
+// random suffix, so two processes on the same host can still be told apart.
+func newInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return host + "-" + hex.EncodeToString(suffix)
+}
+
+// replicasFromEnv reads the REPLICAS hint set by the deployment, defaulting to 1.
+func replicasFromEnv() int {
+	n, err := strconv.Atoi(os.Getenv("REPLICAS"))
+	if err != nil || n < 1 {
+		return 1
+	}
+	return n
+}
+
+// warnIfReplicated logs a warning when we're told more than one replica is
+// running. Each replica has its own datastore (in memory, or a local SQLite
+// file), so behind a load balancer clients would see different data
+// depending on which one answers.
+func (s *server) warnIfReplicated() {
+	if s.replicas > 1 {
+		s.logf("WARNING running as 1 of %d replicas with a local %s datastore: "+
+			"replicas do not share data, so clients may see inconsistent results", s.replicas, s.storeKind)
+	}
+}
+
+// replicaInfo is the body returned by GET /admin/replica-info.
+type replicaInfo struct {
+	InstanceID string `json:"instance_id"`
+	Replicas   int    `json:"replicas"`
+	Store      string `json:"store"`
+	SharedData bool   `json:"shared_data"`
+}
+
+// handleReplicaInfo handles requests for the identity of this instance (e.g.,
+// GET /admin/replica-info). A client that sees the instance ID change between
+// requests knows it's being balanced across replicas that don't share data.
+func (s *server) handleReplicaInfo() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info := replicaInfo{
+			InstanceID: s.instanceID,
+			Replicas:   s.replicas,
+			Store:      s.storeKind,
+			SharedData: false, // Neither datastore is shared between replicas.
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(info)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestReplicaInfo checks that a multi-replica hint produces a startup warning
+// and that the replica info endpoint reports this instance's ID.
+func TestReplicaInfo(t *testing.T) {
+	t.Setenv("REPLICAS", "3")
+
+	server := newServer()
+	var logs bytes.Buffer
+	server.logger = log.New(&logs, "", 0)
+	server.replicas = replicasFromEnv()
+	server.warnIfReplicated()
+
+	if !strings.Contains(logs.String(), "WARNING running as 1 of 3 replicas") {
+		t.Errorf("missing replica warning in log:\n%s", logs.String())
+	}
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/replica-info", nil))
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	var info replicaInfo
+	if err := json.NewDecoder(rr.Body).Decode(&info); err != nil {
+		t.Fatalf("could not decode response body: %v", err)
+	}
+	want := replicaInfo{InstanceID: server.instanceID, Replicas: 3, Store: "memory"}
+	if info != want || info.InstanceID == "" {
+		t.Errorf("got %+v want %+v", info, want)
+	}
+}
+
+// TestReplicaInfoSingle checks that a single replica doesn't warn.
+func TestReplicaInfoSingle(t *testing.T) {
+	t.Setenv("REPLICAS", "")
+
+	server := newServer()
+	var logs bytes.Buffer
+	server.logger = log.New(&logs, "", 0)
+	server.replicas = replicasFromEnv()
+	server.warnIfReplicated()
+
+	if logs.Len() != 0 {
+		t.Errorf("unexpected log output:\n%s", logs.String())
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+)
+
+// requestIDHeader carries a request's correlation ID, both ways: a client or
+// proxy may send one in, and every response sends back the one that was used.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength caps how long an incoming request ID may be.
+const maxRequestIDLength = 128
+
+// requestIDKey is the context key assignRequestID files the ID under.
+type requestIDKey struct{}
+
+// assignRequestID is a middleware that gives every request a correlation ID:
+// the X-Request-ID it came with, or a new UUID if it had none (or one not
+// fit to put in a log). The ID goes on the request context, where the logging
+// helpers pick it up, and on the response, so a client can quote it back.
+func (s *server) assignRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newUUID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// requestIDFromContext returns the request ID assignRequestID gave the request
+// ctx belongs to, or "" outside of a request.
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID reports whether a client-supplied ID can be used as it is:
+// not empty, not too long, and only printable ASCII without spaces, so it
+// can't break up or forge log lines.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newUUID returns a random (version 4) UUID, like
+// "1b4e28ba-2fa1-41d2-883f-0016d3cca427".
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40 // Version 4.
+	b[8] = b[8]&0x3f | 0x80 // The RFC 4122 variant.
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// uuidPattern matches a version 4 UUID.
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+// TestAssignRequestID checks that a supplied request ID is echoed back and
+// seen by the handler, and that a new one is generated when there is none or
+// it isn't usable.
+func TestAssignRequestID(t *testing.T) {
+	server := newServer()
+	var seen string
+	handler := server.assignRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = requestIDFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		name, sent string
+		generated  bool
+	}{
+		{"supplied", "trace-1234", false},
+		{"absent", "", true},
+		{"with spaces", "two words", true},
+		{"too long", strings.Repeat("x", maxRequestIDLength+1), true},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		if tt.sent != "" {
+			req.Header.Set("X-Request-ID", tt.sent)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		got := rr.Header().Get("X-Request-ID")
+		if got != seen {
+			t.Errorf("%s: response has ID %q but the handler saw %q", tt.name, got, seen)
+		}
+		if tt.generated && !uuidPattern.MatchString(got) {
+			t.Errorf("%s: got ID %q, want a new UUID", tt.name, got)
+		}
+		if !tt.generated && got != tt.sent {
+			t.Errorf("%s: got ID %q, want %q echoed", tt.name, got, tt.sent)
+		}
+	}
+}
+
+// TestRequestIDInLogs checks that the lines logged while serving a request
+// carry its ID, in the plain-text log and in JSON.
+func TestRequestIDInLogs(t *testing.T) {
+	server := newServer()
+	var logs bytes.Buffer
+	server.logger = log.New(&logs, "", 0)
+
+	req := httptest.NewRequest("DELETE", "/items/404", nil)
+	req.Header.Set("X-Request-ID", "abc-123")
+	server.router.ServeHTTP(httptest.NewRecorder(), req)
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		if !strings.HasPrefix(line, "[abc-123] ") {
+			t.Errorf("text log line %q does not start with the request ID", line)
+		}
+	}
+
+	logs.Reset()
+	server.jsonLog = newJSONLogger(&logs, &server.logLevel)
+	server.router.ServeHTTP(httptest.NewRecorder(), req)
+	dec := json.NewDecoder(&logs)
+	lines := 0
+	for ; dec.More(); lines++ {
+		var entry map[string]any
+		if err := dec.Decode(&entry); err != nil {
+			t.Fatalf("log output is not JSON lines: %v", err)
+		}
+		if entry["request_id"] != "abc-123" {
+			t.Errorf("JSON log line %v has no request_id of abc-123", entry)
+		}
+	}
+	// The handler's line about the missing item, then the access log.
+	if lines < 2 {
+		t.Errorf("got %d JSON log lines, want at least 2", lines)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"time"
+)
+
+// retryJitterFromEnv reads RETRY_AFTER_JITTER, a Go duration like "10s": the
+// most that gets added at random to each Retry-After we send. Without it,
+// every client is told the same wait.
+func retryJitterFromEnv() time.Duration {
+	d, err := time.ParseDuration(os.Getenv("RETRY_AFTER_JITTER"))
+	if err != nil || d < 0 {
+		return 0
+	}
+	return d
+}
+
+// retryAfter returns a Retry-After value in whole seconds: base plus a random
+// share of s.retryJitter. Clients turned away together then come back spread
+// out over the jitter range, instead of all at once.
+func (s *server) retryAfter(base time.Duration) string {
+	wait := base
+	if s.retryJitter > 0 {
+		wait += rand.N(s.retryJitter + time.Second)
+	}
+	return strconv.Itoa(int(wait / time.Second))
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestRetryAfterJitter checks that paused clients are told to wait different
+// amounts of time, all within the base wait plus the configured jitter.
+func TestRetryAfterJitter(t *testing.T) {
+	server := newServer()
+	server.retryJitter = 10 * time.Second
+	server.paused.Store(true)
+
+	base := int(pauseRetryAfter / time.Second)
+	seen := make(map[int]bool)
+	for range 50 {
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, httptest.NewRequest("GET", "/items", nil))
+		if status := rr.Code; status != http.StatusServiceUnavailable {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusServiceUnavailable)
+		}
+		secs, err := strconv.Atoi(rr.Header().Get("Retry-After"))
+		if err != nil {
+			t.Fatalf("Retry-After is not a number of seconds: %q", rr.Header().Get("Retry-After"))
+		}
+		if secs < base || secs > base+10 {
+			t.Errorf("Retry-After %d is outside [%d, %d]", secs, base, base+10)
+		}
+		seen[secs] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("Retry-After was the same every time: %v", seen)
+	}
+}
+
+// TestRetryAfterNoJitter checks that without jitter the wait is exactly the base.
+func TestRetryAfterNoJitter(t *testing.T) {
+	server := &server{}
+	if got := server.retryAfter(30 * time.Second); got != "30" {
+		t.Errorf("got Retry-After %q want 30", got)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// fieldSchema describes one field of the Item type for API clients: its JSON
+// name, its JSON type and the constraints the server enforces on it.
+type fieldSchema struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Format    string `json:"format,omitempty"`    // Finer detail on Type, like "date-time".
+	ReadOnly  bool   `json:"read_only,omitempty"` // Set by the server; ignored when sent.
+	Required  bool   `json:"required"`
+	Min       *int   `json:"min,omitempty"`        // Smallest allowed value, for integers.
+	Max       *int   `json:"max,omitempty"`        // Largest allowed value, for integers.
+	MinLength *int   `json:"min_length,omitempty"` // Shortest allowed value, for strings.
+}
+
+// intPtr returns a pointer to n, for filling in optional constraints.
+func intPtr(n int) *int { return &n }
+
+// itemSchema is the hand-maintained description of Item. Keep it in step with
+// the struct and with Item.Validate.
+var itemSchema = struct {
+	Type   string        `json:"type"`
+	Fields []fieldSchema `json:"fields"`
+}{
+	Type: "Item",
+	Fields: []fieldSchema{
+		{Name: "id", Type: "integer"}, // Assigned by the server when left out.
+		{Name: "name", Type: "string", Required: true, MinLength: intPtr(1)},
+		{Name: "age", Type: "integer", Min: intPtr(0)},
+		{Name: "position", Type: "integer"},
+		{Name: "created_at", Type: "string", Format: "date-time", ReadOnly: true},
+		{Name: "updated_at", Type: "string", Format: "date-time", ReadOnly: true},
+		{Name: "modified_by", Type: "string", ReadOnly: true},
+	},
+}
+
+// handleSchema handles requests for the description of the Item type (e.g.,
+// GET /schema), which clients can use to generate code or build forms.
+func (s *server) handleSchema() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(itemSchema)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestHandleSchema checks that the schema lists every Item field with its type.
+func TestHandleSchema(t *testing.T) {
+	server := newServer()
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("GET", "/schema", nil))
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+
+	var schema struct {
+		Fields []fieldSchema `json:"fields"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&schema); err != nil {
+		t.Fatalf("could not decode response body: %v", err)
+	}
+
+	types := make(map[string]string)
+	for _, f := range schema.Fields {
+		types[f.Name] = f.Type
+	}
+	for name, want := range map[string]string{"id": "integer", "name": "string", "age": "integer"} {
+		if got := types[name]; got != want {
+			t.Errorf("field %q: got type %q want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"unsafe"
+)
+
+// defaultMemoryBudget is the memory GET /admin/sizing plans against when
+// MEMORY_BUDGET_MB isn't set: 256 MiB.
+const defaultMemoryBudget = 256 << 20
+
+// memoryBudgetFromEnv reads the sizing budget from MEMORY_BUDGET_MB, in
+// megabytes. A missing or unusable value gets the default.
+func memoryBudgetFromEnv() uint64 {
+	mb, err := strconv.ParseUint(os.Getenv("MEMORY_BUDGET_MB"), 10, 64)
+	if err != nil || mb == 0 {
+		return defaultMemoryBudget
+	}
+	return mb << 20
+}
+
+// itemSize estimates the bytes item takes up in memory: the struct itself plus
+// the bytes of its name, which the struct only points at. Map overhead, the
+// JSON cache and the allocator's rounding aren't counted, so it's a floor
+// rather than an exact figure.
+func itemSize(item Item) uint64 {
+	return uint64(unsafe.Sizeof(item)) + uint64(len(item.Name))
+}
+
+// sizing is the body returned by GET /admin/sizing.
+type sizing struct {
+	Items        int    `json:"items"`
+	BytesPerItem uint64 `json:"bytes_per_item"` // The average, or a name-less item's size when there are none.
+	TotalBytes   uint64 `json:"total_bytes"`
+	BudgetBytes  uint64 `json:"budget_bytes"`
+	// CapacityItems is how many items of the average size fit in the budget,
+	// and RemainingItems how many more can be added before it's used up.
+	CapacityItems  uint64 `json:"capacity_items"`
+	RemainingItems uint64 `json:"remaining_items"`
+}
+
+// handleSizing handles requests for an estimate of how much memory the items
+// use and how many fit in the memory budget (e.g., GET /admin/sizing), for
+// capacity planning.
+func (s *server) handleSizing() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.RLock()
+		items := s.sortedItems()
+		s.mu.RUnlock()
+
+		report := sizing{
+			Items:        len(items),
+			BytesPerItem: itemSize(Item{}),
+			BudgetBytes:  s.memoryBudget,
+		}
+		for _, item := range items {
+			report.TotalBytes += itemSize(item)
+		}
+		if len(items) > 0 {
+			report.BytesPerItem = report.TotalBytes / uint64(len(items))
+		}
+		report.CapacityItems = report.BudgetBytes / report.BytesPerItem
+		if report.CapacityItems > uint64(len(items)) {
+			report.RemainingItems = report.CapacityItems - uint64(len(items))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
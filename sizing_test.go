@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestHandleSizing checks that the sizing figures are positive and that the
+// total grows with the number of items while the capacity left shrinks.
+func TestHandleSizing(t *testing.T) {
+	server := newServer()
+
+	get := func() sizing {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/sizing", nil))
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+		}
+		var report sizing
+		if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+			t.Fatalf("could not decode response body: %v", err)
+		}
+		return report
+	}
+
+	for id := 1; id <= 10; id++ {
+		server.putItem(Item{ID: id, Name: "Alice", Age: 30})
+	}
+	ten := get()
+	if ten.Items != 10 || ten.BytesPerItem == 0 || ten.TotalBytes == 0 || ten.CapacityItems == 0 {
+		t.Fatalf("expected positive figures for 10 items, got %+v", ten)
+	}
+	if ten.BudgetBytes != defaultMemoryBudget {
+		t.Errorf("got budget %d want %d", ten.BudgetBytes, defaultMemoryBudget)
+	}
+
+	for id := 11; id <= 20; id++ {
+		server.putItem(Item{ID: id, Name: "Alice", Age: 30})
+	}
+	twenty := get()
+	if twenty.TotalBytes != 2*ten.TotalBytes {
+		t.Errorf("total for 20 items is %d, want twice the %d for 10", twenty.TotalBytes, ten.TotalBytes)
+	}
+	if twenty.RemainingItems != ten.RemainingItems-10 {
+		t.Errorf("remaining capacity went from %d to %d, want it 10 lower", ten.RemainingItems, twenty.RemainingItems)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+)
+
+// maxSnapshots is how many datastore snapshots we keep. Taking one more drops the oldest.
+const maxSnapshots = 10
+
+// snapshot is a labelled copy of the datastore at a point in time.
+type snapshot struct {
+	label string
+	taken time.Time
+	items map[int]Item
+}
+
+// itemChange is an item that exists in both snapshots of a diff, but differs.
+type itemChange struct {
+	Before Item `json:"before"`
+	After  Item `json:"after"`
+}
+
+// snapshotDiff is the body returned by GET /admin/diff.
+type snapshotDiff struct {
+	Added   []Item       `json:"added"`
+	Removed []Item       `json:"removed"`
+	Changed []itemChange `json:"changed"`
+}
+
+// handleTakeSnapshot handles requests to save a copy of the datastore under a
+// label (e.g., POST /admin/snapshot with {"label":"before-import"}). Reusing a
+// label replaces the old snapshot.
+func (s *server) handleTakeSnapshot() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Label string `json:"label"`
+		}
+		err := s.decodeJSON(r, &req)
+		if err != nil {
+			s.reqLogf(r, "ERROR decoding request body: %v", err)
+			writeJSONError(w, http.StatusBadRequest, decodeErrorMessage(err))
+			return
+		}
+		if req.Label == "" {
+			writeJSONError(w, http.StatusBadRequest, "Bad request: label is required")
+			return
+		}
+
+		s.mu.RLock()
+		items := s.sortedItems()
+		s.mu.RUnlock()
+		snap := snapshot{label: req.Label, taken: time.Now(), items: make(map[int]Item, len(items))}
+		for _, item := range items {
+			snap.items[item.ID] = item
+		}
+
+		s.snapshotsMu.Lock()
+		s.snapshots = slices.DeleteFunc(s.snapshots, func(old snapshot) bool { return old.label == snap.label })
+		s.snapshots = append(s.snapshots, snap)
+		if len(s.snapshots) > maxSnapshots {
+			s.snapshots = s.snapshots[len(s.snapshots)-maxSnapshots:]
+		}
+		s.snapshotsMu.Unlock()
+		s.reqLogf(r, "Took snapshot %q of %d item(s)", snap.label, len(snap.items))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]any{
+			"label": snap.label,
+			"taken": snap.taken,
+			"items": len(snap.items),
+		})
+	}
+}
+
+// handleDiffSnapshots handles requests for the differences between two
+// snapshots (e.g., GET /admin/diff?from=before&to=after).
+func (s *server) handleDiffSnapshots() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fromLabel, toLabel := r.URL.Query().Get("from"), r.URL.Query().Get("to")
+		if fromLabel == "" || toLabel == "" {
+			writeJSONError(w, http.StatusBadRequest, "Bad request: from and to are required")
+			return
+		}
+
+		from, foundFrom := s.findSnapshot(fromLabel)
+		to, foundTo := s.findSnapshot(toLabel)
+		if !foundFrom || !foundTo {
+			missing := fromLabel
+			if foundFrom {
+				missing = toLabel
+			}
+			writeJSONError(w, http.StatusNotFound, fmt.Sprintf("Snapshot %q not found", missing))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(diffSnapshots(from, to))
+	}
+}
+
+// findSnapshot looks up a snapshot by its label.
+func (s *server) findSnapshot(label string) (snapshot, bool) {
+	s.snapshotsMu.Lock()
+	defer s.snapshotsMu.Unlock()
+	for _, snap := range s.snapshots {
+		if snap.label == label {
+			return snap, true
+		}
+	}
+	return snapshot{}, false
+}
+
+// diffSnapshots works out which items were added, removed or changed between
+// from and to. Each list is ordered by ID.
+func diffSnapshots(from, to snapshot) snapshotDiff {
+	diff := snapshotDiff{Added: []Item{}, Removed: []Item{}, Changed: []itemChange{}}
+	for id, after := range to.items {
+		before, found := from.items[id]
+		switch {
+		case !found:
+			diff.Added = append(diff.Added, after)
+		case before != after:
+			diff.Changed = append(diff.Changed, itemChange{Before: before, After: after})
+		}
+	}
+	for id, before := range from.items {
+		if _, found := to.items[id]; !found {
+			diff.Removed = append(diff.Removed, before)
+		}
+	}
+
+	byID := func(items []Item) {
+		sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	}
+	byID(diff.Added)
+	byID(diff.Removed)
+	sort.Slice(diff.Changed, func(i, j int) bool { return diff.Changed[i].After.ID < diff.Changed[j].After.ID })
+	return diff
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestSnapshotDiff takes a snapshot, adds, changes and removes items, takes a
+// second snapshot, and checks the diff between them.
+func TestSnapshotDiff(t *testing.T) {
+	server := newServer()
+	server.putItem(Item{ID: 1, Name: "Alice", Age: 30})
+	server.putItem(Item{ID: 2, Name: "Bob", Age: 40})
+	server.putItem(Item{ID: 3, Name: "Carol", Age: 50})
+
+	take := func(label string) {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/admin/snapshot", strings.NewReader(`{"label":"`+label+`"}`))
+		server.router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("snapshot %q: got %v want %v", label, rr.Code, http.StatusCreated)
+		}
+	}
+
+	take("before")
+	server.putItem(Item{ID: 2, Name: "Bob", Age: 41})
+	server.removeItem(3)
+	server.putItem(Item{ID: 4, Name: "Dave", Age: 60})
+	take("after")
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/diff?from=before&to=after", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("diff: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	var got snapshotDiff
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatalf("could not decode response body: %v", err)
+	}
+	want := snapshotDiff{
+		Added:   []Item{{ID: 4, Name: "Dave", Age: 60}},
+		Removed: []Item{{ID: 3, Name: "Carol", Age: 50}},
+		Changed: []itemChange{{Before: Item{ID: 2, Name: "Bob", Age: 40}, After: Item{ID: 2, Name: "Bob", Age: 41}}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got diff %+v want %+v", got, want)
+	}
+
+	// Asking for a snapshot that was never taken is a 404.
+	rr = httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/diff?from=before&to=nope", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("unknown snapshot: got %v want %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+// TestSnapshotLimit checks that only the newest maxSnapshots snapshots are kept.
+func TestSnapshotLimit(t *testing.T) {
+	server := newServer()
+	for i := 0; i <= maxSnapshots; i++ {
+		rr := httptest.NewRecorder()
+		body := `{"label":"s` + string(rune('a'+i)) + `"}`
+		server.router.ServeHTTP(rr, httptest.NewRequest("POST", "/admin/snapshot", strings.NewReader(body)))
+	}
+
+	if got := len(server.snapshots); got != maxSnapshots {
+		t.Errorf("kept %d snapshots, want %d", got, maxSnapshots)
+	}
+	if _, found := server.findSnapshot("sa"); found {
+		t.Error("the oldest snapshot was not dropped")
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	_ "modernc.org/sqlite" // Registers the pure-Go "sqlite" driver, so no cgo is needed.
+)
+
+// sqliteStore is a Datastore backed by a SQLite database file, so the items
+// survive restarts without the JSON data file and don't all have to fit in memory.
+//
+// The Datastore methods that can't return an error (Get and List) treat a
+// database failure like an empty result, so they log it through logf: a
+// broken database shouldn't pass for an empty one without a trace.
+type sqliteStore struct {
+	db   *sql.DB
+	logf func(format string, args ...any)
+}
+
+// sqliteSchema creates the items table the first time a database is opened.
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS items (
+	id          INTEGER PRIMARY KEY,
+	name        TEXT    NOT NULL,
+	age         INTEGER NOT NULL,
+	position    INTEGER NOT NULL DEFAULT 0,
+	created_at  TEXT    NOT NULL,
+	updated_at  TEXT    NOT NULL,
+	modified_by TEXT    NOT NULL DEFAULT ''
+)`
+
+// newSQLiteStore opens (or creates) the SQLite database at path, making sure
+// the items table exists. ":memory:" gives a private in-memory database.
+func newSQLiteStore(path string) (*sqliteStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows one writer at a time anyway, and with a single
+	// connection an in-memory database is the same database for every query.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating items table: %w", err)
+	}
+	if err := addMissingColumns(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqliteStore{db: db, logf: log.Printf}, nil
+}
+
+// sqliteAddedColumns are the columns items gained after the table was first
+// created, with their definitions.
+var sqliteAddedColumns = []struct{ name, definition string }{
+	{"position", "INTEGER NOT NULL DEFAULT 0"},
+	{"modified_by", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// addMissingColumns brings a database created by an older version up to
+// date. CREATE TABLE IF NOT EXISTS leaves an existing table as it is, so any
+// columns added since have to be added separately.
+func addMissingColumns(db *sql.DB) error {
+	for _, col := range sqliteAddedColumns {
+		var n int
+		err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('items') WHERE name = ?`, col.name).Scan(&n)
+		if err != nil {
+			return fmt.Errorf("adding %s column: %w", col.name, err)
+		}
+		if n > 0 {
+			continue
+		}
+		if _, err := db.Exec(`ALTER TABLE items ADD COLUMN ` + col.name + ` ` + col.definition); err != nil {
+			return fmt.Errorf("adding %s column: %w", col.name, err)
+		}
+	}
+	return nil
+}
+
+func (st *sqliteStore) Create(item Item) error {
+	res, err := st.db.Exec(
+		`INSERT INTO items (id, name, age, position, created_at, updated_at, modified_by) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO NOTHING`,
+		item.ID, item.Name, item.Age, item.Position, formatTime(item.CreatedAt), formatTime(item.UpdatedAt), item.ModifiedBy)
+	return rowsChanged(res, err, errItemExists)
+}
+
+func (st *sqliteStore) Get(id int) (Item, bool) {
+	row := st.db.QueryRow(`SELECT id, name, age, position, created_at, updated_at, modified_by FROM items WHERE id = ?`, id)
+	item, err := scanItem(row)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			st.logf("ERROR reading item %d from SQLite: %v", id, err)
+		}
+		return Item{}, false
+	}
+	return item, true
+}
+
+func (st *sqliteStore) Update(id int, item Item) error {
+	res, err := st.db.Exec(
+		`UPDATE items SET name = ?, age = ?, position = ?, created_at = ?, updated_at = ?, modified_by = ? WHERE id = ?`,
+		item.Name, item.Age, item.Position, formatTime(item.CreatedAt), formatTime(item.UpdatedAt), item.ModifiedBy, id)
+	return rowsChanged(res, err, errItemNotFound)
+}
+
+func (st *sqliteStore) Delete(id int) error {
+	res, err := st.db.Exec(`DELETE FROM items WHERE id = ?`, id)
+	return rowsChanged(res, err, errItemNotFound)
+}
+
+func (st *sqliteStore) List() []Item {
+	items := []Item{}
+	rows, err := st.db.Query(`SELECT id, name, age, position, created_at, updated_at, modified_by FROM items ORDER BY id`)
+	if err != nil {
+		st.logf("ERROR listing items from SQLite: %v", err)
+		return items
+	}
+	defer rows.Close()
+	for rows.Next() {
+		item, err := scanItem(rows)
+		if err != nil {
+			st.logf("ERROR listing items from SQLite: %v", err)
+			return []Item{}
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		st.logf("ERROR listing items from SQLite: %v", err)
+		return []Item{}
+	}
+	return items
+}
+
+func (st *sqliteStore) Len() int {
+	var n int
+	if err := st.db.QueryRow(`SELECT COUNT(*) FROM items`).Scan(&n); err != nil {
+		st.logf("ERROR counting items in SQLite: %v", err)
+		return 0
+	}
+	return n
+}
+
+// Close closes the database.
+func (st *sqliteStore) Close() error {
+	return st.db.Close()
+}
+
+// rowsChanged turns the result of an INSERT, UPDATE or DELETE into a
+// Datastore error: err itself if the statement failed, or none if it failed
+// to touch a row (the ID was taken, or wasn't there).
+func rowsChanged(res sql.Result, err error, none error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return none
+	}
+	return nil
+}
+
+// scanner is what *sql.Row and *sql.Rows have in common.
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanItem reads one items row.
+func scanItem(row scanner) (Item, error) {
+	var item Item
+	var created, updated string
+	if err := row.Scan(&item.ID, &item.Name, &item.Age, &item.Position, &created, &updated, &item.ModifiedBy); err != nil {
+		return Item{}, err
+	}
+	var err error
+	if item.CreatedAt, err = time.Parse(time.RFC3339Nano, created); err != nil {
+		return Item{}, err
+	}
+	if item.UpdatedAt, err = time.Parse(time.RFC3339Nano, updated); err != nil {
+		return Item{}, err
+	}
+	return item, nil
+}
+
+// sqliteTimeLayout is RFC 3339 with all nine digits of the fraction kept.
+// time.RFC3339Nano trims trailing zeros, so its text doesn't sort in time
+// order ("…:05Z" sorts after "…:05.5Z"); a fixed width does.
+const sqliteTimeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// formatTime stores timestamps as fixed-width RFC 3339 text in UTC, which
+// SQLite's date functions understand and which sorts in time order. Parsing
+// with time.RFC3339Nano reads it back, along with rows written before.
+func formatTime(t time.Time) string {
+	return t.UTC().Format(sqliteTimeLayout)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestSQLiteStore opens a fresh in-memory SQLite store for one test.
+func newTestSQLiteStore(t *testing.T) *sqliteStore {
+	t.Helper()
+	st, err := newSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("could not open SQLite store: %v", err)
+	}
+	t.Cleanup(func() { st.Close() })
+	return st
+}
+
+// TestSQLiteStore runs the Datastore checks against an in-memory SQLite database.
+func TestSQLiteStore(t *testing.T) {
+	testDatastore(t, newTestSQLiteStore(t))
+}
+
+// TestSQLiteStoreTimestamps checks that timestamps come back out of the
+// database exactly as they went in.
+func TestSQLiteStoreTimestamps(t *testing.T) {
+	st := newTestSQLiteStore(t)
+	created := time.Date(2025, 6, 24, 12, 0, 0, 123456789, time.UTC)
+	want := Item{ID: 1, Name: "Alice", Age: 30, CreatedAt: created, UpdatedAt: created.Add(time.Hour)}
+	if err := st.Create(want); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	got, found := st.Get(1)
+	if !found || !got.CreatedAt.Equal(want.CreatedAt) || !got.UpdatedAt.Equal(want.UpdatedAt) {
+		t.Errorf("Get: got %+v want %+v", got, want)
+	}
+	if items := st.List(); len(items) != 1 || !items[0].UpdatedAt.Equal(want.UpdatedAt) {
+		t.Errorf("List: got %+v", items)
+	}
+}
+
+// TestSQLiteStoreSurvivesRestart checks that items written through the API
+// are still there after the database file is closed and opened again.
+func TestSQLiteStoreSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "items.db")
+	t.Setenv("STORE", "sqlite")
+	t.Setenv("SQLITE_PATH", path)
+
+	server := newServer()
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("POST", "/items", strings.NewReader(`{"id":5,"name":"Durable","age":9}`)))
+	if status := rr.Code; status != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+	}
+	server.store.(*sqliteStore).Close()
+
+	restarted := newServer()
+	defer restarted.store.(*sqliteStore).Close()
+	rr = httptest.NewRecorder()
+	restarted.router.ServeHTTP(rr, httptest.NewRequest("GET", "/items/5", nil))
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if !strings.Contains(rr.Body.String(), `"name":"Durable"`) {
+		t.Errorf("handler returned unexpected body: %s", rr.Body.String())
+	}
+}
+
+// TestSQLiteStoreAddsMissingColumns opens a database made before items had a
+// position or a modified_by, and checks the columns are added so both can be stored.
+func TestSQLiteStoreAddsMissingColumns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "items.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("could not open database: %v", err)
+	}
+	_, err = db.Exec(`CREATE TABLE items (
+		id INTEGER PRIMARY KEY, name TEXT NOT NULL, age INTEGER NOT NULL,
+		created_at TEXT NOT NULL, updated_at TEXT NOT NULL)`)
+	db.Close()
+	if err != nil {
+		t.Fatalf("could not create old table: %v", err)
+	}
+
+	st, err := newSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("could not open SQLite store: %v", err)
+	}
+	defer st.Close()
+	if err := st.Create(Item{ID: 1, Name: "Alice", Age: 30, Position: 4, ModifiedBy: "alice"}); err != nil {
+		t.Fatalf("could not create item: %v", err)
+	}
+	if got, _ := st.Get(1); got.Position != 4 || got.ModifiedBy != "alice" {
+		t.Errorf("got position %d and modified_by %q, want 4 and %q", got.Position, got.ModifiedBy, "alice")
+	}
+}
+
+// TestSQLiteStoreLogsErrors checks that a database failure in Get or List is
+// logged rather than passing silently for a missing item or an empty store,
+// while a genuinely missing item isn't.
+func TestSQLiteStoreLogsErrors(t *testing.T) {
+	st := newTestSQLiteStore(t)
+	var logged []string
+	st.logf = func(format string, args ...any) { logged = append(logged, fmt.Sprintf(format, args...)) }
+
+	if _, found := st.Get(1); found || len(logged) != 0 {
+		t.Errorf("missing item: found %v, logged %q", found, logged)
+	}
+
+	st.db.Close()
+	if _, found := st.Get(1); found || len(logged) != 1 {
+		t.Errorf("Get on a closed database: found %v, logged %q", found, logged)
+	}
+	if items := st.List(); len(items) != 0 || len(logged) != 2 {
+		t.Errorf("List on a closed database: got %v, logged %q", items, logged)
+	}
+}
+
+// TestFormatTimeSorts checks stored timestamps sort as text in time order,
+// even when the fraction of a second ends in zeros.
+func TestFormatTimeSorts(t *testing.T) {
+	base := time.Date(2025, 6, 24, 12, 0, 5, 0, time.UTC)
+	times := []time.Time{base, base.Add(500 * time.Millisecond), base.Add(time.Second)}
+	for i := 1; i < len(times); i++ {
+		if a, b := formatTime(times[i-1]), formatTime(times[i]); a >= b {
+			t.Errorf("%q doesn't sort before %q", a, b)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Errors returned by Datastore implementations.
+var (
+	errItemExists   = errors.New("item already exists")
+	errItemNotFound = errors.New("item not found")
+)
+
+// Datastore is where items are kept. The server only talks to storage through
+// this interface, so the in-memory map can be swapped for a database without
+// touching the handlers.
+//
+// Every method must be safe to call from several goroutines at once. Handlers
+// that need more than one call to act as a unit (like a compare-and-set, or an
+// all-or-nothing import) hold the server's own lock around them.
+type Datastore interface {
+	// Create stores a new item, or returns errItemExists if its ID is taken.
+	Create(item Item) error
+	// Get returns the item with the given ID, and whether there is one.
+	Get(id int) (Item, bool)
+	// Update replaces the item with the given ID, or returns errItemNotFound.
+	Update(id int, item Item) error
+	// Delete removes the item with the given ID, or returns errItemNotFound.
+	Delete(id int) error
+	// List returns every item, ordered by ID. It never returns nil.
+	List() []Item
+	// Len returns how many items there are, without listing them.
+	Len() int
+}
+
+// memStore is the default Datastore: a map in memory, lost when the process
+// exits (unless the server saves it to its data file first).
+type memStore struct {
+	mu    sync.RWMutex
+	items map[int]Item // The key is the item ID.
+}
+
+// newMemStore returns an empty in-memory store.
+func newMemStore() *memStore {
+	// Initialize the map! Otherwise, it's nil and will cause a crash.
+	return &memStore{items: make(map[int]Item)}
+}
+
+func (m *memStore) Create(item Item) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, found := m.items[item.ID]; found {
+		return errItemExists
+	}
+	m.items[item.ID] = item
+	return nil
+}
+
+func (m *memStore) Get(id int) (Item, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	// The "value, found" is a common Go idiom for checking if a key exists in a map.
+	item, found := m.items[id]
+	return item, found
+}
+
+func (m *memStore) Update(id int, item Item) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, found := m.items[id]; !found {
+		return errItemNotFound
+	}
+	m.items[id] = item
+	return nil
+}
+
+func (m *memStore) Delete(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, found := m.items[id]; !found {
+		return errItemNotFound
+	}
+	delete(m.items, id)
+	return nil
+}
+
+// List sorts the items by ID: map iteration order is random in Go, so sorting
+// keeps the output stable between requests.
+func (m *memStore) List() []Item {
+	m.mu.RLock()
+	items := make([]Item, 0, len(m.items))
+	for _, item := range m.items {
+		items = append(items, item)
+	}
+	m.mu.RUnlock()
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	return items
+}
+
+func (m *memStore) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.items)
+}
+
+// storeFromEnv picks the backend named by STORE: "memory" (the default) or
+// "sqlite", whose database file is SQLITE_PATH (items.db by default).
+func storeFromEnv() (Datastore, string, error) {
+	switch kind := os.Getenv("STORE"); kind {
+	case "", "memory":
+		return newMemStore(), "memory", nil
+	case "sqlite":
+		path := os.Getenv("SQLITE_PATH")
+		if path == "" {
+			path = "items.db"
+		}
+		st, err := newSQLiteStore(path)
+		if err != nil {
+			return nil, "", fmt.Errorf("opening SQLite database %s: %w", path, err)
+		}
+		return st, kind, nil
+	default:
+		return nil, "", fmt.Errorf(`STORE must be "memory" or "sqlite", got %q`, kind)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"testing"
+)
+
+// TestMemStore runs the Datastore checks against the in-memory store.
+func TestMemStore(t *testing.T) {
+	testDatastore(t, newMemStore())
+}
+
+// testDatastore walks an item through its life in m, checking the errors for
+// missing and duplicate items along the way. Every Datastore should pass it.
+func testDatastore(t *testing.T, m Datastore) {
+	t.Helper()
+
+	if err := m.Create(Item{ID: 2, Name: "Bob", Age: 40}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := m.Create(Item{ID: 1, Name: "Alice", Age: 30}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if err := m.Create(Item{ID: 1, Name: "Again", Age: 1}); !errors.Is(err, errItemExists) {
+		t.Errorf("Create with a taken ID: got %v want %v", err, errItemExists)
+	}
+
+	if item, found := m.Get(1); !found || item.Name != "Alice" {
+		t.Errorf("Get(1): got %+v, %v", item, found)
+	}
+	if _, found := m.Get(3); found {
+		t.Error("Get(3): found an item that was never created")
+	}
+
+	if err := m.Update(1, Item{ID: 1, Name: "Alice Smith", Age: 31}); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if item, _ := m.Get(1); item.Name != "Alice Smith" {
+		t.Errorf("Update didn't stick: got %+v", item)
+	}
+	if err := m.Update(3, Item{ID: 3, Name: "Nobody"}); !errors.Is(err, errItemNotFound) {
+		t.Errorf("Update of a missing item: got %v want %v", err, errItemNotFound)
+	}
+
+	if items := m.List(); len(items) != 2 || items[0].ID != 1 || items[1].ID != 2 {
+		t.Errorf("List is not in ID order: %+v", items)
+	}
+	if n := m.Len(); n != 2 {
+		t.Errorf("Len: got %d want 2", n)
+	}
+
+	if err := m.Delete(2); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := m.Delete(2); !errors.Is(err, errItemNotFound) {
+		t.Errorf("second Delete: got %v want %v", err, errItemNotFound)
+	}
+	if items := m.List(); len(items) != 1 {
+		t.Errorf("List after Delete: got %+v", items)
+	}
+}
+
+// TestMemStoreListEmpty checks that an empty store lists as an empty slice,
+// which encodes as [] rather than null.
+func TestMemStoreListEmpty(t *testing.T) {
+	if items := newMemStore().List(); items == nil || len(items) != 0 {
+		t.Errorf("List on an empty store: got %#v want an empty slice", items)
+	}
+}
+
+// TestStoreFromEnv checks that STORE picks the backend, and that unknown
+// backends are refused.
+func TestStoreFromEnv(t *testing.T) {
+	t.Setenv("SQLITE_PATH", ":memory:")
+	for value, want := range map[string]string{"": "memory", "memory": "memory", "sqlite": "sqlite"} {
+		t.Setenv("STORE", value)
+		store, kind, err := storeFromEnv()
+		if err != nil {
+			t.Errorf("STORE=%q: %v", value, err)
+			continue
+		}
+		if kind != want {
+			t.Errorf("STORE=%q: got kind %q want %q", value, kind, want)
+		}
+		if closer, ok := store.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+
+	t.Setenv("STORE", "postgres")
+	if _, _, err := storeFromEnv(); err == nil {
+		t.Error("STORE=postgres: expected an error, got none")
+	}
+}
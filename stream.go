@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// handleStreamItems handles requests to stream the stored items as
+// newline-delimited JSON, one item per line, ordered by ID (e.g., GET
+// /items/stream?min_age=18). Each line is flushed as soon as it's written, so
+// clients can start on the first items without waiting for the rest.
+func (s *server) handleStreamItems() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseItemFilter(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Bad request: %v", err))
+			return
+		}
+
+		// Take a copy of the items and let go of the lock before writing, so a
+		// slow reader on the other end doesn't hold up writers.
+		s.mu.RLock()
+		items := s.sortedItems()
+		s.mu.RUnlock()
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		rc := http.NewResponseController(w)
+		enc := json.NewEncoder(w) // Encode ends every value with a newline.
+		for _, item := range items {
+			// Stop as soon as the client goes away; there's no one left to read the rest.
+			if err := r.Context().Err(); err != nil {
+				return
+			}
+			if !filter.matches(item) {
+				continue
+			}
+			if err := enc.Encode(item); err != nil {
+				s.reqLogf(r, "ERROR streaming items: %v", err)
+				return
+			}
+			// Some writers (like the HTTP/1.0 buffer) can't flush; the items
+			// then simply arrive together at the end.
+			rc.Flush()
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestStreamItemsMinAge checks that only items at or above min_age are
+// streamed, one JSON object per line, in ID order.
+func TestStreamItemsMinAge(t *testing.T) {
+	s := newServer()
+	for _, item := range []Item{
+		{ID: 1, Name: "Kid", Age: 10},
+		{ID: 2, Name: "Adult", Age: 30},
+		{ID: 3, Name: "Teen", Age: 17},
+		{ID: 4, Name: "Grown", Age: 18},
+	} {
+		s.putItem(item)
+	}
+
+	req, _ := http.NewRequest("GET", "/items/stream?min_age=18", nil)
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("wrong Content-Type: got %q want application/x-ndjson", ct)
+	}
+	if !rr.Flushed {
+		t.Error("expected the stream to be flushed as it was written")
+	}
+
+	var got []int
+	scanner := bufio.NewScanner(rr.Body)
+	for scanner.Scan() {
+		var item Item
+		if err := json.Unmarshal(scanner.Bytes(), &item); err != nil {
+			t.Fatalf("line %q is not an item: %v", scanner.Text(), err)
+		}
+		got = append(got, item.ID)
+	}
+	if len(got) != 2 || got[0] != 2 || got[1] != 4 {
+		t.Errorf("streamed wrong items: got IDs %v want [2 4]", got)
+	}
+}
+
+// TestStreamItemsStopsWhenCanceled checks that nothing is streamed once the
+// client has gone away.
+func TestStreamItemsStopsWhenCanceled(t *testing.T) {
+	s := newServer()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", "/items/stream", nil)
+	rr := httptest.NewRecorder()
+	s.handleStreamItems().ServeHTTP(rr, req)
+
+	if rr.Body.Len() != 0 {
+		t.Errorf("expected an empty body for a canceled request, got %q", rr.Body.String())
+	}
+}
+
+// TestStreamItemsInvalidMinAge checks that a non-numeric min_age is rejected.
+func TestStreamItemsInvalidMinAge(t *testing.T) {
+	s := newServer()
+
+	req, _ := http.NewRequest("GET", "/items/stream?min_age=old", nil)
+	rr := httptest.NewRecorder()
+	s.router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+}
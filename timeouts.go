@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// The connection timeouts used when the environment doesn't set them. Without
+// any, a client can hold a connection open forever by sending its request a
+// byte at a time (slowloris), or by never reading the response.
+const (
+	defaultReadTimeout  = 10 * time.Second
+	defaultWriteTimeout = 15 * time.Second
+	defaultIdleTimeout  = 60 * time.Second
+)
+
+// serverTimeouts are the connection timeouts set on the http.Server: how long
+// reading a whole request may take, how long from the end of reading it until
+// the response is written, and how long a keep-alive connection may sit idle.
+type serverTimeouts struct {
+	read, write, idle time.Duration
+}
+
+// serverTimeoutsFromEnv reads the timeouts from READ_TIMEOUT, WRITE_TIMEOUT and
+// IDLE_TIMEOUT, Go durations like "30s". Missing or unusable values get the
+// defaults; the errors say what was wrong with the unusable ones.
+func serverTimeoutsFromEnv() (serverTimeouts, []error) {
+	var errs []error
+	read := func(name string, def time.Duration) time.Duration {
+		d, err := durationFromEnv(name, def)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s (%v), using %v", name, err, d))
+		}
+		return d
+	}
+	t := serverTimeouts{
+		read:  read("READ_TIMEOUT", defaultReadTimeout),
+		write: read("WRITE_TIMEOUT", defaultWriteTimeout),
+		idle:  read("IDLE_TIMEOUT", defaultIdleTimeout),
+	}
+	return t, errs
+}
+
+// apply sets the timeouts on srv.
+func (t serverTimeouts) apply(srv *http.Server) {
+	srv.ReadTimeout = t.read
+	srv.WriteTimeout = t.write
+	srv.IdleTimeout = t.idle
+}
+
+// durationFromEnv reads a positive Go duration from the environment variable
+// name. A missing or unusable value gets def; the second result says what was
+// wrong with it, if anything.
+func durationFromEnv(name string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return def, err
+	}
+	if d <= 0 {
+		return def, fmt.Errorf("%s must be positive, got %s", name, v)
+	}
+	return d, nil
+}
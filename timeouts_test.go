@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestServerTimeoutsFromEnv checks the defaults, overriding each timeout, and
+// the fallback to the default for an unusable value.
+func TestServerTimeoutsFromEnv(t *testing.T) {
+	t.Setenv("READ_TIMEOUT", "")
+	t.Setenv("WRITE_TIMEOUT", "")
+	t.Setenv("IDLE_TIMEOUT", "")
+	got, errs := serverTimeoutsFromEnv()
+	want := serverTimeouts{read: defaultReadTimeout, write: defaultWriteTimeout, idle: defaultIdleTimeout}
+	if got != want || len(errs) != 0 {
+		t.Errorf("defaults: got %+v, %v want %+v", got, errs, want)
+	}
+
+	t.Setenv("READ_TIMEOUT", "5s")
+	t.Setenv("WRITE_TIMEOUT", "forever")
+	t.Setenv("IDLE_TIMEOUT", "2m")
+	got, errs = serverTimeoutsFromEnv()
+	want = serverTimeouts{read: 5 * time.Second, write: defaultWriteTimeout, idle: 2 * time.Minute}
+	if got != want || len(errs) != 1 {
+		t.Errorf("overrides: got %+v, %v want %+v and one error", got, errs, want)
+	}
+
+	var srv http.Server
+	got.apply(&srv)
+	if srv.ReadTimeout != got.read || srv.WriteTimeout != got.write || srv.IdleTimeout != got.idle {
+		t.Errorf("apply set read %v, write %v, idle %v", srv.ReadTimeout, srv.WriteTimeout, srv.IdleTimeout)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+)
+
+// tlsFiles names the certificate and private key files HTTPS is served with.
+type tlsFiles struct {
+	cert, key string
+}
+
+// tlsFilesFromEnv reads TLS_CERT and TLS_KEY, both PEM file paths. The server
+// speaks HTTPS when both are set and plain HTTP when neither is, which is what
+// the second result says; setting only one of them is an error, since that's
+// surely a mistake rather than a request for plain HTTP.
+func tlsFilesFromEnv() (tlsFiles, bool, error) {
+	files := tlsFiles{cert: os.Getenv("TLS_CERT"), key: os.Getenv("TLS_KEY")}
+	switch {
+	case files.cert == "" && files.key == "":
+		return tlsFiles{}, false, nil
+	case files.cert == "" || files.key == "":
+		return tlsFiles{}, false, errors.New("TLS_CERT and TLS_KEY must be set together")
+	}
+	return files, true, nil
+}
+
+// config loads the certificate and key. Doing it up front, instead of leaving
+// it to ServeTLS, means a bad file stops the server before it claims to be
+// serving.
+func (f tlsFiles) config() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(f.cert, f.key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
+// serve accepts connections on ln until srv is shut down, over TLS if
+// srv.TLSConfig is set and over plain HTTP otherwise. Shutting down works the
+// same either way, so main doesn't have to care which it is.
+func serve(srv *http.Server, ln net.Listener) error {
+	if srv.TLSConfig != nil {
+		// The certificate is already in TLSConfig, so no files are named here.
+		return srv.ServeTLS(ln, "", "")
+	}
+	return srv.Serve(ln)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+// TestTLSFilesFromEnv checks that TLS is on only when both TLS_CERT and
+// TLS_KEY are set, and that setting just one is an error.
+func TestTLSFilesFromEnv(t *testing.T) {
+	tests := []struct {
+		cert, key string
+		wantTLS   bool
+		wantErr   bool
+	}{
+		{"", "", false, false},
+		{"cert.pem", "key.pem", true, false},
+		{"cert.pem", "", false, true},
+		{"", "key.pem", false, true},
+	}
+	for _, tt := range tests {
+		t.Setenv("TLS_CERT", tt.cert)
+		t.Setenv("TLS_KEY", tt.key)
+		files, useTLS, err := tlsFilesFromEnv()
+		if useTLS != tt.wantTLS || (err != nil) != tt.wantErr {
+			t.Errorf("TLS_CERT=%q TLS_KEY=%q: got TLS %v, error %v", tt.cert, tt.key, useTLS, err)
+		}
+		if useTLS && (files.cert != tt.cert || files.key != tt.key) {
+			t.Errorf("TLS_CERT=%q TLS_KEY=%q: got files %+v", tt.cert, tt.key, files)
+		}
+	}
+}
+
+// TestTLSFilesConfigMissing checks that a missing certificate is reported
+// when loading it, before the server starts serving.
+func TestTLSFilesConfigMissing(t *testing.T) {
+	dir := t.TempDir()
+	files := tlsFiles{cert: filepath.Join(dir, "cert.pem"), key: filepath.Join(dir, "key.pem")}
+	if _, err := files.config(); err == nil {
+		t.Errorf("expected an error loading missing files")
+	}
+}
+
+// TestServeSelectsTLS checks that serve speaks TLS exactly when the server has
+// a TLS config, without doing a real handshake: Go's TLS server answers a
+// plain HTTP request with a 400 instead.
+func TestServeSelectsTLS(t *testing.T) {
+	for _, withTLS := range []bool{false, true} {
+		srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+		if withTLS {
+			srv.TLSConfig = &tls.Config{GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+				return nil, errors.New("no certificate in this test")
+			}}
+		}
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("could not listen: %v", err)
+		}
+		done := make(chan error, 1)
+		go func() { done <- serve(srv, ln) }()
+
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			t.Fatalf("TLS %v: request failed: %v", withTLS, err)
+		}
+		resp.Body.Close()
+		want := http.StatusOK
+		if withTLS {
+			want = http.StatusBadRequest
+		}
+		if resp.StatusCode != want {
+			t.Errorf("TLS %v: plain HTTP request got %v want %v", withTLS, resp.StatusCode, want)
+		}
+
+		// Closing works the same in both modes.
+		srv.Close()
+		if err := <-done; err != http.ErrServerClosed {
+			t.Errorf("TLS %v: serve returned %v want %v", withTLS, err, http.ErrServerClosed)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// itemValidation is the verdict on one item of a batch validation. Errors
+// lists every problem with the item, not just the first.
+type itemValidation struct {
+	Index  int      `json:"index"`
+	Valid  bool     `json:"valid"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// handleValidateBatch handles requests to check a whole batch of items
+// against the same rules as create and update, without storing any of them
+// (e.g., POST /items/validate-batch with a JSON array of items). The response
+// is always 200 OK with one result per item, in the same order, so a form
+// can mark every bad row in one go.
+func (s *server) handleValidateBatch() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var items []Item
+		if err := s.decodeJSON(r, &items); err != nil {
+			s.reqLogf(r, "ERROR decoding request body: %v", err)
+			writeJSONError(w, http.StatusBadRequest, decodeErrorMessage(err))
+			return
+		}
+
+		results := make([]itemValidation, len(items))
+		for i, item := range items {
+			results[i] = itemValidation{Index: i, Valid: true}
+			for _, err := range item.validationErrors() {
+				results[i].Valid = false
+				results[i].Errors = append(results[i].Errors, err.Error())
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(results)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestHandleValidateBatch checks that every item gets its own verdict, listing
+// all of its problems, and that nothing gets stored.
+func TestHandleValidateBatch(t *testing.T) {
+	server := newServer()
+	body := `[
+		{"id":1,"name":"Alice","age":30},
+		{"id":2,"name":"","age":30},
+		{"id":3,"name":"","age":-1},
+		{"id":4,"name":"Dave","age":-5}
+	]`
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("POST", "/items/validate-batch", strings.NewReader(body)))
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var results []itemValidation
+	if err := json.NewDecoder(rr.Body).Decode(&results); err != nil {
+		t.Fatalf("could not decode response body: %v", err)
+	}
+	want := []itemValidation{
+		{Index: 0, Valid: true},
+		{Index: 1, Valid: false, Errors: []string{"name must not be empty"}},
+		{Index: 2, Valid: false, Errors: []string{"name must not be empty", "age must not be negative"}},
+		{Index: 3, Valid: false, Errors: []string{"age must not be negative"}},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results want %d: %+v", len(results), len(want), results)
+	}
+	for i := range want {
+		if results[i].Index != want[i].Index || results[i].Valid != want[i].Valid || !slices.Equal(results[i].Errors, want[i].Errors) {
+			t.Errorf("result %d: got %+v want %+v", i, results[i], want[i])
+		}
+	}
+
+	if len(server.store.List()) != 0 {
+		t.Errorf("validation stored items: %v", server.store.List())
+	}
+}
+
+// TestHandleValidateBatchNotArray checks that a body that isn't an array of
+// items is a bad request.
+func TestHandleValidateBatchNotArray(t *testing.T) {
+	server := newServer()
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, httptest.NewRequest("POST", "/items/validate-batch", strings.NewReader(`{"id":1}`)))
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+}